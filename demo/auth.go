@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ---------------------- 鉴权 ----------------------
+// /ws 握手和 /talk/* 请求都通过 Authenticator 解析当前用户，
+// 业务里的 send_id / user_id 等"我是谁"的字段以令牌为准，不再信任请求体。
+// 令牌由上游登录服务调用 POST /auth/token 签发。
+
+// Identity 当前登录用户
+type Identity struct {
+	UserID int
+	Name   string
+	Avatar string
+}
+
+// Authenticator 可插拔的鉴权实现
+type Authenticator interface {
+	// Authenticate 校验令牌并返回用户身份
+	Authenticate(token string) (*Identity, error)
+	// Issue 为用户签发令牌，返回令牌和过期时间
+	Issue(id *Identity, ttl time.Duration) (string, time.Time, error)
+}
+
+var (
+	errNoToken      = errors.New("missing token")
+	errInvalidToken = errors.New("invalid token")
+)
+
+// ---------------------- HMAC JWT ----------------------
+type jwtAuthenticator struct {
+	secret []byte
+	issuer string
+}
+
+type chatClaims struct {
+	Name   string `json:"name,omitempty"`
+	Avatar string `json:"avatar,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (a *jwtAuthenticator) Authenticate(token string) (*Identity, error) {
+	if token == "" {
+		return nil, errNoToken
+	}
+	var claims chatClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (any, error) {
+		return a.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(a.issuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	uid, err := strconv.Atoi(claims.Subject)
+	if err != nil || uid <= 0 {
+		return nil, errInvalidToken
+	}
+	return &Identity{UserID: uid, Name: claims.Name, Avatar: claims.Avatar}, nil
+}
+
+func (a *jwtAuthenticator) Issue(id *Identity, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	exp := now.Add(ttl)
+	claims := chatClaims{
+		Name:   id.Name,
+		Avatar: id.Avatar,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(id.UserID),
+			Issuer:    a.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(exp),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.secret)
+	return token, exp, err
+}
+
+// ---------------------- 初始化 ----------------------
+var (
+	authenticator  Authenticator // 为 nil 表示未开启鉴权（仅限本地调试）
+	authServiceKey string
+	authTokenTTL   time.Duration
+	authMaxTTL     time.Duration // POST /auth/token 可指定的最长有效期
+)
+
+func initAuth(ctx context.Context) {
+	driver := g.Cfg().MustGet(ctx, "auth.driver", "jwt").String()
+	authServiceKey = g.Cfg().MustGet(ctx, "auth.serviceKey").String()
+	if v := os.Getenv("AUTH_SERVICE_KEY"); v != "" {
+		authServiceKey = v
+	}
+	if isPlaceholderSecret(authServiceKey) {
+		panic("auth.serviceKey is a placeholder: set a random key or leave it empty")
+	}
+	authTokenTTL = time.Duration(g.Cfg().MustGet(ctx, "auth.ttl", 86400).Int()) * time.Second
+	authMaxTTL = time.Duration(g.Cfg().MustGet(ctx, "auth.maxTTL", int(authTokenTTL/time.Second)).Int()) * time.Second
+	if authTokenTTL <= 0 || authMaxTTL < authTokenTTL {
+		panic("auth.ttl must be positive and not greater than auth.maxTTL")
+	}
+
+	switch driver {
+	case "jwt":
+		secret := g.Cfg().MustGet(ctx, "auth.secret").String()
+		if v := os.Getenv("AUTH_SECRET"); v != "" {
+			secret = v
+		}
+		if secret == "" {
+			panic("missing auth secret: set config \"auth.secret\" or env AUTH_SECRET")
+		}
+		if isPlaceholderSecret(secret) || len(secret) < authMinSecretLen {
+			panic(fmt.Sprintf("auth.secret is too weak: use a random string of at least %d bytes", authMinSecretLen))
+		}
+		authenticator = &jwtAuthenticator{
+			secret: []byte(secret),
+			issuer: g.Cfg().MustGet(ctx, "auth.issuer", "we-demo-chat").String(),
+		}
+	case "none":
+		g.Log().Warning(ctx, "auth disabled: user identity is taken from request params")
+	default:
+		panic("unknown auth driver: " + driver)
+	}
+}
+
+// authMinSecretLen HS256 密钥最短长度
+const authMinSecretLen = 32
+
+// isPlaceholderSecret 示例配置里的占位密钥，不能用于部署
+func isPlaceholderSecret(s string) bool {
+	s = strings.ToLower(s)
+	return strings.Contains(s, "change-me") || strings.Contains(s, "changeme") || s == "secret"
+}
+
+// ---------------------- 请求身份 ----------------------
+const ctxKeyIdentity = "auth.identity"
+
+// requestToken 依次从 Authorization: Bearer、token 参数中取令牌（浏览器 WebSocket 无法自定义请求头）
+func requestToken(r *ghttp.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	return r.Get("token").String()
+}
+
+// authenticate 解析当前请求的身份；未开启鉴权时返回 nil, nil
+func authenticate(r *ghttp.Request) (*Identity, error) {
+	if authenticator == nil {
+		return nil, nil
+	}
+	return authenticator.Authenticate(requestToken(r))
+}
+
+// authMiddleware 鉴权中间件
+func authMiddleware(r *ghttp.Request) {
+	id, err := authenticate(r)
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 401, "msg": "未登录或登录已过期"})
+		return
+	}
+	if id != nil {
+		r.SetCtxVar(ctxKeyIdentity, id)
+	}
+	r.Middleware.Next()
+}
+
+// currentIdentity 取中间件解析出的身份
+func currentIdentity(r *ghttp.Request) *Identity {
+	if id, ok := r.GetCtxVar(ctxKeyIdentity).Val().(*Identity); ok {
+		return id
+	}
+	return nil
+}
+
+// authUID 当前用户ID；未开启鉴权时使用请求参数里的 fallback
+func authUID(r *ghttp.Request, fallback int) int {
+	if id := currentIdentity(r); id != nil {
+		return id.UserID
+	}
+	return fallback
+}
+
+// ---------------------- 签发令牌 ----------------------
+
+// 供上游登录服务调用，需携带服务密钥
+// POST /auth/token
+// header: X-Service-Key: <auth.serviceKey>
+// body: { "user_id":1, "name":"张三", "avatar":"https://...", "ttl":86400 }
+func issueTokenHandler(r *ghttp.Request) {
+	if authenticator == nil {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "未开启鉴权"})
+		return
+	}
+	key := r.Header.Get("X-Service-Key")
+	if authServiceKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(authServiceKey)) != 1 {
+		r.Response.WriteJsonExit(g.Map{"code": 403, "msg": "没有权限"})
+		return
+	}
+
+	var req struct {
+		UserID int    `json:"user_id"`
+		Name   string `json:"name"`
+		Avatar string `json:"avatar"`
+		TTL    int    `json:"ttl"` // 秒，不传用 auth.ttl，不能超过 auth.maxTTL
+	}
+	if err := r.Parse(&req); err != nil || req.UserID <= 0 || req.TTL < 0 {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	ttl := authTokenTTL
+	if req.TTL > 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+	if ttl > authMaxTTL {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": fmt.Sprintf("ttl 不能超过 %d 秒", int64(authMaxTTL/time.Second))})
+		return
+	}
+
+	token, exp, err := authenticator.Issue(&Identity{UserID: req.UserID, Name: req.Name, Avatar: req.Avatar}, ttl)
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 500, "msg": "签发失败"})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": g.Map{
+		"token":      token,
+		"expires_at": exp.Unix(),
+	}})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// ---------------------- 历史数据回填：message.sid ----------------------
+// 用法：go run . backfill-sid
+// sid 字段恢复之前写入的消息 sid 为 0，按 (send_id, receiver_id) 找到对应会话回填，规则见 backfillSessionOf。
+func backfillSessionIDs(ctx context.Context) error {
+	type pair struct {
+		SendID     int
+		ReceiverID int
+	}
+	var pairs []pair
+	if err := db.Model(&TalkMessage{}).
+		Select("DISTINCT send_id, receiver_id").
+		Where("sid = 0 OR sid IS NULL").
+		Scan(&pairs).Error; err != nil {
+		return err
+	}
+
+	var total int64
+	for _, p := range pairs {
+		sess, err := backfillSessionOf(p.SendID, p.ReceiverID)
+		if err != nil {
+			g.Log().Warningf(ctx, "backfill sid: no session for send_id=%d receiver_id=%d", p.SendID, p.ReceiverID)
+			continue
+		}
+
+		res := db.Model(&TalkMessage{}).
+			Where("(sid = 0 OR sid IS NULL) AND send_id=? AND receiver_id=?", p.SendID, p.ReceiverID).
+			Update("sid", sess.ID)
+		if res.Error != nil {
+			return res.Error
+		}
+		total += res.RowsAffected
+	}
+	g.Log().Infof(ctx, "backfill sid done: %d messages updated", total)
+	return nil
+}
+
+// backfillSessionOf 历史消息 (send_id, receiver_id) 所属的单聊会话，与线上写入 sid 的方式保持一致：
+//   - 会话由发起方创建（/talk/session/save、review 新建会话时 send_id 都是发送者自己），
+//     发送者在自己名下（send_id 为自己）的会话里发消息，review 写入的 sid 即 findPairSession(send_id, receiver_id)，
+//     所以优先同方向的会话；
+//   - 只有对方创建的会话时，双方共用这一条，回复方的消息落在反方向的会话上；
+//   - 同方向存在多条时取最早创建的一条，与 FindPair 一致。
+//
+// 群聊会话 send_id/receiver_id 均为 0，不参与匹配。
+func backfillSessionOf(sendID, receiverID int) (*TalkSession, error) {
+	var sess TalkSession
+	err := db.Where("type<>? AND send_id=? AND receiver_id=?", SessionTypeGroup, sendID, receiverID).
+		Order("id asc").First(&sess).Error
+	if err != nil {
+		err = db.Where("type<>? AND send_id=? AND receiver_id=?", SessionTypeGroup, receiverID, sendID).
+			Order("id asc").First(&sess).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// useSQLite 每个测试一个临时 SQLite 库并执行全部迁移
+func useSQLite(t *testing.T) context.Context {
+	t.Helper()
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("SQLITE_PATH", t.TempDir()+"/test.db")
+	ctx := context.Background()
+	initDB(ctx)
+	if err := migrateUp(ctx, 0); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return ctx
+}
+
+func TestBackfillSessionIDs(t *testing.T) {
+	ctx := useSQLite(t)
+	newSession := func(sendID, receiverID int) *TalkSession {
+		s := &TalkSession{SendID: sendID, ReceiverID: receiverID, Status: 1, Type: 1, UpdatedAt: time.Now()}
+		if err := store.Sessions.Create(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	// review 创建的双向会话：1↔2
+	newSession(1, 2)
+	newSession(2, 1)
+	// 3 创建、4 只是接收方的单条会话
+	only := newSession(3, 4)
+	// 群聊不参与匹配
+	if err := store.Sessions.CreateGroup(ctx, &TalkSession{Type: SessionTypeGroup, Status: 1, Name: "g"},
+		[]SessionMember{{UserID: 1, Role: MemberRoleOwner, Status: 1, JoinedAt: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		sendID, receiverID int
+		want               func() int
+	}{
+		// 与 review 写入 sid 的方式一致：发送者自己名下的会话
+		{1, 2, func() int { s, _ := store.Sessions.FindPair(ctx, 1, 2); return s.ID }},
+		{2, 1, func() int { s, _ := store.Sessions.FindPair(ctx, 2, 1); return s.ID }},
+		// 只有对方创建的会话时，双方共用
+		{3, 4, func() int { return only.ID }},
+		{4, 3, func() int { return only.ID }},
+	}
+	ids := make([]int, len(cases))
+	for i, c := range cases {
+		m := &TalkMessage{SendID: c.sendID, ReceiverID: c.receiverID, MsgType: MsgTypeText, Content: "hi", CreatedAt: time.Now()}
+		if err := db.Create(m).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = m.ID
+	}
+	// 没有会话的消息保持 sid=0
+	orphan := &TalkMessage{SendID: 5, ReceiverID: 6, MsgType: MsgTypeText, Content: "hi", CreatedAt: time.Now()}
+	if err := db.Create(orphan).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := backfillSessionIDs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range cases {
+		var m TalkMessage
+		if err := db.First(&m, ids[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+		if want := c.want(); m.Sid != want {
+			t.Errorf("send_id=%d receiver_id=%d: sid=%d, want %d", c.sendID, c.receiverID, m.Sid, want)
+		}
+	}
+	var m TalkMessage
+	if err := db.First(&m, orphan.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if m.Sid != 0 {
+		t.Errorf("orphan message sid=%d, want 0", m.Sid)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/gogf/gf/contrib/nosql/redis/v2"
+	"github.com/gogf/gf/v2/database/gredis"
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// ---------------------- 集群：跨节点推送 & 在线状态 ----------------------
+// 单节点模式下直接走本地 hub；开启 cluster.enabled 后：
+//   - 推送事件发布到 Redis 频道，所有节点收到后投递给本机连接的用户
+//   - 在线状态登记在 Redis，"用户是否在线" 按整个集群判断
+//
+// Redis 结构：
+//   im:presence:<uid>        hash    field=<node>|<device>  value=<过期时间戳>:<online|away>
+//   im:presence:online       zset    member=uid             score=最近一次续期的过期时间戳
+//   im:presence:state:<uid>  string  最近一次发布的聚合状态，跨节点判断状态是否变化
+
+const (
+	presenceKeyPrefix      = "im:presence:"
+	presenceOnlineKey      = "im:presence:online"
+	presenceStateKeyPrefix = "im:presence:state:"
+)
+
+type Cluster struct {
+	enabled     bool
+	nodeID      string
+	channel     string
+	presenceTTL time.Duration
+	redis       *gredis.Redis
+}
+
+// 跨节点推送的信封
+type clusterEnvelope struct {
+	Node    string          `json:"node"`
+	UIDs    []int           `json:"uids,omitempty"`  // 为空表示广播给所有在线用户
+	Watch   int             `json:"watch,omitempty"` // 非 0 表示只投递给订阅了该用户在线状态的连接
+	Key     string          `json:"key,omitempty"`   // 低优先级事件的合并 key
+	Payload json.RawMessage `json:"payload"`
+}
+
+var cluster = &Cluster{nodeID: defaultNodeID()}
+
+func defaultNodeID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// ---------------------- 初始化 ----------------------
+func initCluster(ctx context.Context) {
+	if !g.Cfg().MustGet(ctx, "cluster.enabled").Bool() {
+		g.Log().Info(ctx, "cluster disabled, running in single-node mode")
+		return
+	}
+
+	cluster.channel = g.Cfg().MustGet(ctx, "cluster.channel", "im:push").String()
+	cluster.presenceTTL = time.Duration(g.Cfg().MustGet(ctx, "cluster.presenceTTL", 90).Int()) * time.Second
+	if cluster.presenceTTL <= 0 {
+		panic("cluster.presenceTTL must be positive")
+	}
+	if id := g.Cfg().MustGet(ctx, "cluster.nodeId").String(); id != "" {
+		cluster.nodeID = id
+	}
+
+	cluster.redis = g.Redis()
+	if cluster.redis == nil {
+		panic("cluster enabled but redis.default is not configured")
+	}
+	ctxPing, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if _, err := cluster.redis.Do(ctxPing, "PING"); err != nil {
+		panic("Redis ping failed: " + err.Error())
+	}
+	cluster.enabled = true
+
+	go cluster.subscribeLoop(context.Background())
+	go cluster.refreshLoop(context.Background())
+	g.Log().Infof(ctx, "cluster enabled, node=%s channel=%s", cluster.nodeID, cluster.channel)
+}
+
+// ---------------------- 推送 ----------------------
+
+// Push 推送给用户在集群内的所有设备
+func (cl *Cluster) Push(uid int, payload any) {
+	hub.SendToUser(uid, payload)
+	cl.publish([]int{uid}, payload)
+}
+
+// Broadcast 推送给集群内所有在线用户
+func (cl *Cluster) Broadcast(payload any) {
+	hub.Broadcast(payload)
+	cl.publish(nil, payload)
+}
+
+// PushPresence 把 uid 的在线状态变化推给集群内订阅了它的连接
+func (cl *Cluster) PushPresence(uid int, payload any) {
+	presence.deliverLocal(uid, payload)
+	cl.send(clusterEnvelope{Watch: uid}, payload)
+}
+
+func (cl *Cluster) publish(uids []int, payload any) {
+	cl.send(clusterEnvelope{UIDs: uids}, payload)
+}
+
+func (cl *Cluster) send(env clusterEnvelope, payload any) {
+	if !cl.enabled {
+		return
+	}
+	key, payload := splitPayload(payload)
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	env.Node = cl.nodeID
+	env.Key = key
+	env.Payload = data
+	raw, _ := json.Marshal(env)
+	if _, err := cl.redis.Publish(context.Background(), cl.channel, string(raw)); err != nil {
+		g.Log().Warningf(context.Background(), "cluster publish failed: %v", err)
+	}
+}
+
+// 订阅推送频道，断线自动重连
+func (cl *Cluster) subscribeLoop(ctx context.Context) {
+	for {
+		conn, _, err := cl.redis.Subscribe(ctx, cl.channel)
+		if err != nil {
+			g.Log().Warningf(ctx, "cluster subscribe failed: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		for {
+			msg, err := conn.ReceiveMessage(ctx)
+			if err != nil {
+				g.Log().Warningf(ctx, "cluster receive failed: %v", err)
+				break
+			}
+			cl.deliver(msg.Payload)
+		}
+		_ = conn.Close(ctx)
+		time.Sleep(time.Second)
+	}
+}
+
+// 投递来自其他节点的推送（自己发布的已在本地投递过）
+func (cl *Cluster) deliver(raw string) {
+	var env clusterEnvelope
+	if err := json.Unmarshal([]byte(raw), &env); err != nil || env.Node == cl.nodeID {
+		return
+	}
+	payload := coalesced(env.Key, env.Payload)
+	if env.Watch != 0 {
+		presence.deliverLocal(env.Watch, payload)
+		return
+	}
+	if len(env.UIDs) == 0 {
+		hub.Broadcast(payload)
+		return
+	}
+	for _, uid := range env.UIDs {
+		hub.SendToUser(uid, payload)
+	}
+}
+
+// ---------------------- 在线状态 ----------------------
+
+// IsOnline 用户是否在集群任一节点在线
+func (cl *Cluster) IsOnline(uid int) bool {
+	return cl.OnlineSet([]int{uid})[uid]
+}
+
+// OnlineSet 批量判断 uids 是否在集群任一节点在线，本机有连接的不再查 Redis
+func (cl *Cluster) OnlineSet(uids []int) map[int]bool {
+	result := make(map[int]bool, len(uids))
+	var remote []any
+	for _, uid := range uids {
+		if hub.IsOnline(uid) {
+			result[uid] = true
+		} else if cl.enabled {
+			remote = append(remote, uid)
+		}
+	}
+	if len(remote) == 0 {
+		return result
+	}
+	ctx := context.Background()
+	now := time.Now().Unix()
+	// ZMSCORE 一次往返取回所有分数（Redis >= 6.2），不支持时逐个 ZSCORE
+	if v, err := cl.redis.Do(ctx, "ZMSCORE", append([]any{presenceOnlineKey}, remote...)...); err == nil {
+		for i, score := range v.Vars() {
+			if i < len(remote) && !score.IsNil() && score.Int64() > now {
+				result[remote[i].(int)] = true
+			}
+		}
+		return result
+	}
+	for _, uid := range remote {
+		score, err := cl.redis.Do(ctx, "ZSCORE", presenceOnlineKey, uid)
+		if err == nil && !score.IsNil() && score.Int64() > now {
+			result[uid.(int)] = true
+		}
+	}
+	return result
+}
+
+// presenceScript 汇总 uid 各节点登记的最佳状态（online > away > offline），
+// 并与上次发布的状态原子交换，返回 {本次状态, 上次状态}
+const presenceScript = `
+local now = tonumber(ARGV[1])
+local best = 'offline'
+local v = redis.call('HGETALL', KEYS[1])
+for i = 2, #v, 2 do
+	local sep = string.find(v[i], ':', 1, true)
+	local exp = tonumber(sep and string.sub(v[i], 1, sep - 1) or v[i])
+	local st = sep and string.sub(v[i], sep + 1) or 'online'
+	if exp and exp > now then
+		if st == 'away' then
+			if best == 'offline' then best = 'away' end
+		else
+			best = 'online'
+		end
+	end
+end
+local prev = redis.call('GETSET', KEYS[2], best)
+redis.call('EXPIRE', KEYS[2], ARGV[2])
+return {best, prev or ''}`
+
+// SwapStatus 计算 uid 在整个集群的聚合状态，同时记为最近发布的状态；
+// changed 表示与上次发布的不同，由调用方写库并推送
+func (cl *Cluster) SwapStatus(ctx context.Context, uid int) (status string, changed bool, err error) {
+	v, err := cl.redis.Do(ctx, "EVAL", presenceScript, 2,
+		presenceKeyPrefix+strconv.Itoa(uid), presenceStateKeyPrefix+strconv.Itoa(uid),
+		time.Now().Unix(), int64(presenceStateTTL.Seconds()))
+	if err != nil {
+		return "", false, err
+	}
+	res := v.Strings()
+	if len(res) != 2 {
+		return "", false, fmt.Errorf("unexpected presence script result: %v", res)
+	}
+	return res[0], res[0] != res[1], nil
+}
+
+// 聚合状态的保留时间，过期后下一次计算会重新写库/推送一次
+const presenceStateTTL = 24 * time.Hour
+
+// Join 登记本节点上的连接
+func (cl *Cluster) Join(c *Client) {
+	if !cl.enabled {
+		return
+	}
+	cl.touch(context.Background(), c)
+}
+
+// Leave 注销本节点上的连接；同设备已被新连接顶替时保留登记
+func (cl *Cluster) Leave(c *Client) {
+	if !cl.enabled || hub.Device(c.UserID, c.DeviceID) != nil {
+		return
+	}
+	ctx := context.Background()
+	key := presenceKeyPrefix + strconv.Itoa(c.UserID)
+	_, _ = cl.redis.HDel(ctx, key, cl.field(c))
+	if n, err := cl.liveDevices(ctx, c.UserID); err == nil && n == 0 {
+		_, _ = cl.redis.Do(ctx, "ZREM", presenceOnlineKey, c.UserID)
+	}
+}
+
+// liveDevices 统计 uid 未过期的登记数，顺带删除已过期的字段（宕机节点留下的登记）
+func (cl *Cluster) liveDevices(ctx context.Context, uid int) (int, error) {
+	key := presenceKeyPrefix + strconv.Itoa(uid)
+	v, err := cl.redis.HGetAll(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().Unix()
+	live := 0
+	var stale []string
+	for field, value := range v.MapStrStr() {
+		if n, _ := parsePresenceEntry(value); n > now {
+			live++
+		} else {
+			stale = append(stale, field)
+		}
+	}
+	if len(stale) > 0 {
+		_, _ = cl.redis.HDel(ctx, key, stale...)
+	}
+	return live, nil
+}
+
+// parsePresenceEntry 解析登记值 <过期时间戳>:<状态>，旧格式只有时间戳，视为 online
+func parsePresenceEntry(value string) (expireAt int64, status string) {
+	ts, status, ok := strings.Cut(value, ":")
+	if !ok {
+		status = PresenceOnline
+	}
+	expireAt, _ = strconv.ParseInt(ts, 10, 64)
+	return expireAt, status
+}
+
+func (cl *Cluster) field(c *Client) string {
+	return cl.nodeID + "|" + c.DeviceID
+}
+
+func (cl *Cluster) touch(ctx context.Context, c *Client) {
+	expireAt := time.Now().Add(cl.presenceTTL).Unix()
+	key := presenceKeyPrefix + strconv.Itoa(c.UserID)
+	value := fmt.Sprintf("%d:%s", expireAt, presence.connStatus(c))
+	if _, err := cl.redis.HSet(ctx, key, map[string]any{cl.field(c): value}); err != nil {
+		g.Log().Warningf(ctx, "presence touch failed: %v", err)
+		return
+	}
+	_, _ = cl.redis.Expire(ctx, key, int64(cl.presenceTTL.Seconds()))
+	_, _ = cl.redis.Do(ctx, "ZADD", presenceOnlineKey, expireAt, c.UserID)
+}
+
+// 定期为本节点的连接续期，节点宕机后登记会在 presenceTTL 后自然过期
+func (cl *Cluster) refreshLoop(ctx context.Context) {
+	ticker := time.NewTicker(cl.presenceTTL / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, uid := range hub.OnlineUserIDs() {
+			for _, c := range hub.Clients(uid) {
+				cl.touch(ctx, c)
+			}
+		}
+		// 顺带清理已过期的用户
+		_, _ = cl.redis.Do(ctx, "ZREMRANGEBYSCORE", presenceOnlineKey, "-inf", time.Now().Unix())
+	}
+}
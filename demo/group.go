@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// ---------------------- 群聊 ----------------------
+// 群聊复用 session 表（type=2），成员与角色记录在 session_member，
+// 未读数按成员分别计数；群消息 receiver_id 为 0，推送给除发送者外的所有成员。
+
+const (
+	SessionTypeSingle = 1 // 单聊
+	SessionTypeGroup  = 2 // 群聊
+)
+
+const (
+	MemberRoleOwner  = 1 // 群主
+	MemberRoleAdmin  = 2 // 管理员
+	MemberRoleMember = 3 // 普通成员
+)
+
+type SessionMember struct {
+	ID        int       `gorm:"primaryKey;column:id" json:"id"`
+	Sid       int       `gorm:"column:sid;uniqueIndex:uk_sid_user,priority:1" json:"sid"`
+	UserID    int       `gorm:"column:user_id;uniqueIndex:uk_sid_user,priority:2;index" json:"user_id"`
+	Role      int       `gorm:"column:role;default:3" json:"role"` // 1群主 2管理员 3成员
+	UnReadNum int       `gorm:"column:un_read_num" json:"un_read_num"`
+	Status    int       `gorm:"column:status;default:1" json:"status"` // 1在群 0已退出
+	JoinedAt  time.Time `gorm:"column:joined_at" json:"joined_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+}
+
+func (SessionMember) TableName() string { return "session_member" }
+
+// activeMember 返回在群成员，不在群返回 nil
+func activeMember(ctx context.Context, sid, uid int) *SessionMember {
+	m, err := store.Sessions.Member(ctx, sid, uid)
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+// groupMemberIDs 返回群内所有在群成员的 user_id
+func groupMemberIDs(ctx context.Context, sid int) []int {
+	list, _ := store.Sessions.Members(ctx, sid)
+	ids := make([]int, 0, len(list))
+	for _, m := range list {
+		ids = append(ids, m.UserID)
+	}
+	return ids
+}
+
+// 群内广播（不经过消息表），如成员变更
+func pushToGroup(ctx context.Context, sid int, payload any) {
+	for _, uid := range groupMemberIDs(ctx, sid) {
+		cluster.Push(uid, payload)
+	}
+}
+
+// sendGroupMessage 群消息：落库、成员未读 +1、扇出给其他在群成员
+func sendGroupMessage(ctx context.Context, sess *TalkSession, req *SendMessageReq) (*TalkMessage, *bizError) {
+	if activeMember(ctx, sess.ID, req.SendID) == nil {
+		return nil, newBizError(403, "你不在该会话中")
+	}
+	quote, bizErr := loadQuote(ctx, sess.ID, req.ReplyToID)
+	if bizErr != nil {
+		return nil, bizErr
+	}
+
+	msg := &TalkMessage{
+		Sid:         sess.ID,
+		SendID:      req.SendID,
+		MsgType:     req.MsgType,
+		Content:     req.Content,
+		Payload:     req.Payload,
+		Nickname:    req.Nickname,
+		Avatar:      req.Avatar,
+		IsRead:      0,
+		Status:      MsgStatusSent,
+		ClientMsgID: req.clientMsgID(),
+		ReplyToID:   req.ReplyToID,
+		ObjectKey:   req.ObjectKey,
+		Quote:       quote,
+	}
+	err := store.Tx(ctx, func(st *Stores) error {
+		if err := st.Messages.Create(ctx, msg); err != nil {
+			return err
+		}
+		if err := st.Sessions.UpdateLastMessage(ctx, sess.ID, req.Content, 0); err != nil {
+			return err
+		}
+		if err := st.Sessions.IncrMemberUnread(ctx, sess.ID, req.SendID); err != nil {
+			return err
+		}
+
+		rows, err := st.Sessions.Members(ctx, sess.ID)
+		if err != nil {
+			return err
+		}
+		uids := make([]int, 0, len(rows))
+		for _, m := range rows {
+			uids = append(uids, m.UserID)
+		}
+		seqs, err := st.Messages.AppendTimeline(ctx, msg, uids)
+		if err != nil {
+			return err
+		}
+		msg.Seq = seqs[req.SendID]
+		fresh, err := st.Sessions.Get(ctx, sess.ID)
+		if err != nil {
+			return err
+		}
+
+		var batch outboxBatch
+		for _, m := range rows {
+			if m.UserID != req.SendID {
+				batch.message(m.UserID, msg, seqs)
+			}
+		}
+		// 推送最新会话信息，未读数按成员各自填充
+		for _, m := range rows {
+			s := *fresh
+			s.UnReadNum = m.UnReadNum
+			batch.push(m.UserID, sessionUpdatedFrame(s))
+		}
+		return batch.save(ctx, st)
+	})
+	if err != nil {
+		if dup := duplicateOf(ctx, req); dup != nil {
+			return dup, nil
+		}
+		g.Log().Warningf(ctx, "send group message failed, sid=%d: %v", sess.ID, err)
+		return nil, newBizError(500, "保存消息失败")
+	}
+	outbox.kick()
+	indexMessage(ctx, msg)
+	return msg, nil
+}
+
+func notifyMemberChange(ctx context.Context, sid, uid int, action string, role int) {
+	pushToGroup(ctx, sid, map[string]any{
+		"event": "group_member",
+		"data": map[string]any{
+			"sid":     sid,
+			"user_id": uid,
+			"action":  action, // join / leave / kick / role
+			"role":    role,
+		},
+	})
+}
+
+// 加载群会话，非群或不存在返回错误
+func loadGroup(ctx context.Context, sid int) (*TalkSession, *bizError) {
+	sess, err := store.Sessions.Get(ctx, sid)
+	if err != nil || sess.Status != 1 {
+		return nil, newBizError(404, "会话不存在")
+	}
+	if sess.Type != SessionTypeGroup {
+		return nil, newBizError(400, "不是群聊")
+	}
+	return sess, nil
+}
+
+// ---------------------- HTTP Handlers ----------------------
+
+// 创建群聊
+// POST /talk/session/group
+// body: { "owner_id":1, "name":"订单升级处理", "member_ids":[2,3] }
+func createGroupHandler(r *ghttp.Request) {
+	var req struct {
+		OwnerID   int    `json:"owner_id"`
+		Name      string `json:"name"`
+		MemberIDs []int  `json:"member_ids"`
+	}
+	err := r.Parse(&req)
+	req.OwnerID = authUID(r, req.OwnerID) // 以令牌身份为准
+	if err != nil || req.OwnerID == 0 || req.Name == "" {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+
+	s := &TalkSession{
+		Type:      SessionTypeGroup,
+		Name:      req.Name,
+		IsOnline:  2,
+		Status:    1,
+		UpdatedAt: time.Now(),
+	}
+	now := time.Now()
+	members := []SessionMember{{UserID: req.OwnerID, Role: MemberRoleOwner, Status: 1, JoinedAt: now}}
+	seen := map[int]bool{req.OwnerID: true}
+	for _, uid := range req.MemberIDs {
+		if uid == 0 || seen[uid] {
+			continue
+		}
+		seen[uid] = true
+		members = append(members, SessionMember{UserID: uid, Role: MemberRoleMember, Status: 1, JoinedAt: now})
+	}
+	if err := store.Sessions.CreateGroup(r.Context(), s, members); err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 500, "msg": "创建会话失败"})
+		return
+	}
+
+	pushToGroup(r.Context(), s.ID, sessionUpdatedFrame(*s))
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "操作成功", "data": g.Map{"sid": s.ID}})
+}
+
+// 添加成员：群主/管理员把用户拉进群
+// POST /talk/session/add
+// body: { "sid":1001, "operator_id":1, "user_id":5 }
+func addMemberHandler(r *ghttp.Request) {
+	var req struct {
+		Sid        int `json:"sid"`
+		OperatorID int `json:"operator_id"`
+		UserID     int `json:"user_id"`
+	}
+	err := r.Parse(&req)
+	req.OperatorID = authUID(r, req.OperatorID) // 以令牌身份为准
+	if err != nil || req.Sid == 0 || req.OperatorID == 0 || req.UserID == 0 {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	ctx := r.Context()
+	sess, bizErr := loadGroup(ctx, req.Sid)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
+	op := activeMember(ctx, sess.ID, req.OperatorID)
+	if op == nil || op.Role > MemberRoleAdmin {
+		r.Response.WriteJsonExit(g.Map{"code": 403, "msg": "没有权限"})
+		return
+	}
+	if activeMember(ctx, sess.ID, req.UserID) != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "已在群中"})
+		return
+	}
+	if err := store.Sessions.AddMember(ctx, sess.ID, req.UserID, MemberRoleMember); err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 500, "msg": "添加失败"})
+		return
+	}
+	typing.invalidate(sess.ID)
+
+	notifyMemberChange(ctx, sess.ID, req.UserID, "join", MemberRoleMember)
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "操作成功"})
+}
+
+// 退出群聊；群主退出时转让给最早加入的管理员/成员，无人可转让则解散
+// POST /talk/session/leave
+// body: { "sid":1001, "user_id":5 }
+func leaveGroupHandler(r *ghttp.Request) {
+	var req struct {
+		Sid    int `json:"sid"`
+		UserID int `json:"user_id"`
+	}
+	err := r.Parse(&req)
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if err != nil || req.Sid == 0 || req.UserID == 0 {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	ctx := r.Context()
+	sess, bizErr := loadGroup(ctx, req.Sid)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
+	me := activeMember(ctx, sess.ID, req.UserID)
+	if me == nil {
+		r.Response.WriteJsonExit(g.Map{"code": 403, "msg": "你不在该会话中"})
+		return
+	}
+
+	// 先通知（包含自己），再退出
+	notifyMemberChange(ctx, sess.ID, req.UserID, "leave", me.Role)
+	_ = store.Sessions.RemoveMember(ctx, sess.ID, req.UserID)
+	typing.invalidate(sess.ID)
+
+	if me.Role == MemberRoleOwner {
+		rest, _ := store.Sessions.Members(ctx, sess.ID)
+		if len(rest) == 0 {
+			_ = store.Sessions.SetStatus(ctx, sess.ID, 0)
+		} else {
+			next := rest[0]
+			_ = store.Sessions.SetMemberRole(ctx, sess.ID, next.UserID, MemberRoleOwner)
+			notifyMemberChange(ctx, sess.ID, next.UserID, "role", MemberRoleOwner)
+		}
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "操作成功"})
+}
+
+// 踢出成员：群主可踢任何人，管理员只能踢普通成员
+// POST /talk/session/kick
+// body: { "sid":1001, "operator_id":1, "user_id":5 }
+func kickMemberHandler(r *ghttp.Request) {
+	var req struct {
+		Sid        int `json:"sid"`
+		OperatorID int `json:"operator_id"`
+		UserID     int `json:"user_id"`
+	}
+	err := r.Parse(&req)
+	req.OperatorID = authUID(r, req.OperatorID) // 以令牌身份为准
+	if err != nil || req.Sid == 0 || req.OperatorID == 0 || req.UserID == 0 || req.OperatorID == req.UserID {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	ctx := r.Context()
+	sess, bizErr := loadGroup(ctx, req.Sid)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
+	op := activeMember(ctx, sess.ID, req.OperatorID)
+	target := activeMember(ctx, sess.ID, req.UserID)
+	if op == nil || target == nil {
+		r.Response.WriteJsonExit(g.Map{"code": 404, "msg": "成员不存在"})
+		return
+	}
+	if op.Role >= target.Role {
+		r.Response.WriteJsonExit(g.Map{"code": 403, "msg": "没有权限"})
+		return
+	}
+
+	notifyMemberChange(ctx, sess.ID, req.UserID, "kick", target.Role)
+	_ = store.Sessions.RemoveMember(ctx, sess.ID, req.UserID)
+	typing.invalidate(sess.ID)
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "操作成功"})
+}
+
+// 设置成员角色（仅群主），role: 2管理员 3成员
+// POST /talk/session/role
+// body: { "sid":1001, "operator_id":1, "user_id":5, "role":2 }
+func setMemberRoleHandler(r *ghttp.Request) {
+	var req struct {
+		Sid        int `json:"sid"`
+		OperatorID int `json:"operator_id"`
+		UserID     int `json:"user_id"`
+		Role       int `json:"role"`
+	}
+	err := r.Parse(&req)
+	req.OperatorID = authUID(r, req.OperatorID) // 以令牌身份为准
+	if err != nil || req.Sid == 0 || req.OperatorID == 0 || req.UserID == 0 ||
+		(req.Role != MemberRoleAdmin && req.Role != MemberRoleMember) {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	ctx := r.Context()
+	sess, bizErr := loadGroup(ctx, req.Sid)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
+	op := activeMember(ctx, sess.ID, req.OperatorID)
+	target := activeMember(ctx, sess.ID, req.UserID)
+	if op == nil || target == nil {
+		r.Response.WriteJsonExit(g.Map{"code": 404, "msg": "成员不存在"})
+		return
+	}
+	if op.Role != MemberRoleOwner || target.Role == MemberRoleOwner {
+		r.Response.WriteJsonExit(g.Map{"code": 403, "msg": "没有权限"})
+		return
+	}
+
+	_ = store.Sessions.SetMemberRole(ctx, sess.ID, req.UserID, req.Role)
+	notifyMemberChange(ctx, sess.ID, req.UserID, "role", req.Role)
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "操作成功"})
+}
+
+// 群成员列表（仅群成员可查看）
+// POST /talk/session/members
+// body: { "sid":1001, "user_id":1 }
+func groupMembersHandler(r *ghttp.Request) {
+	var req struct {
+		Sid    int `json:"sid"`
+		UserID int `json:"user_id"`
+	}
+	err := r.Parse(&req)
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if err != nil || req.Sid == 0 || req.UserID == 0 {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	if activeMember(r.Context(), req.Sid, req.UserID) == nil {
+		r.Response.WriteJsonExit(g.Map{"code": 403, "msg": "你不在该会话中"})
+		return
+	}
+	list, err := store.Sessions.Members(r.Context(), req.Sid)
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 500, "msg": "查询失败"})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": list})
+}
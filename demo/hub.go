@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ---------------------- WebSocket 连接 ----------------------
+type Client struct {
+	Conn      *websocket.Conn
+	UserID    int
+	DeviceID  string // 设备标识，同一用户可多端同时在线
+	Protocol  string // 握手协商出的子协议（协议版本），未协商为空
+	Name      string
+	LastPong  time.Time
+	Heartbeat time.Duration
+	FirstPing bool
+
+	queue     *sendQueue    // 出站队列，仅 writePump 消费
+	done      chan struct{} // 连接关闭信号
+	closeOnce sync.Once
+}
+
+// ---------------------- Hub：连接注册与推送 ----------------------
+// Hub 负责连接的注册、查询与扇出推送。
+// 一个用户可以有多个连接（按 DeviceID 区分），推送时发往该用户的全部设备。
+type Hub struct {
+	mu     sync.RWMutex
+	byConn map[*websocket.Conn]*Client
+	byUser map[int]map[string]*Client // user_id -> device_id -> client
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		byConn: make(map[*websocket.Conn]*Client),
+		byUser: make(map[int]map[string]*Client),
+	}
+}
+
+// Register 注册连接；同一用户同一设备重复登录时返回被顶替的旧连接（由调用方关闭）
+func (h *Hub) Register(c *Client) (replaced *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	devices := h.byUser[c.UserID]
+	if devices == nil {
+		devices = make(map[string]*Client)
+		h.byUser[c.UserID] = devices
+	}
+	if old, ok := devices[c.DeviceID]; ok && old != c {
+		delete(h.byConn, old.Conn)
+		replaced = old
+	}
+	devices[c.DeviceID] = c
+	h.byConn[c.Conn] = c
+	return replaced
+}
+
+// Unregister 注销连接；仅当该连接仍是当前登记的连接时才删除，返回是否删除
+func (h *Hub) Unregister(c *Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if cur, ok := h.byConn[c.Conn]; !ok || cur != c {
+		return false
+	}
+	delete(h.byConn, c.Conn)
+	if devices := h.byUser[c.UserID]; devices != nil {
+		if devices[c.DeviceID] == c {
+			delete(devices, c.DeviceID)
+		}
+		if len(devices) == 0 {
+			delete(h.byUser, c.UserID)
+		}
+	}
+	return true
+}
+
+// IsOnline 用户是否至少有一个在线连接
+func (h *Hub) IsOnline(uid int) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.byUser[uid]) > 0
+}
+
+// Device 返回用户指定设备的连接，不存在返回 nil
+func (h *Hub) Device(uid int, deviceID string) *Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.byUser[uid][deviceID]
+}
+
+// Clients 返回用户全部在线连接的快照
+func (h *Hub) Clients(uid int) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	devices := h.byUser[uid]
+	list := make([]*Client, 0, len(devices))
+	for _, c := range devices {
+		list = append(list, c)
+	}
+	return list
+}
+
+// OnlineUserIDs 返回当前有连接的用户ID
+func (h *Hub) OnlineUserIDs() []int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ids := make([]int, 0, len(h.byUser))
+	for uid := range h.byUser {
+		ids = append(ids, uid)
+	}
+	return ids
+}
+
+// SendToUser 推送给用户的所有设备，返回投递的连接数
+func (h *Hub) SendToUser(uid int, payload any) int {
+	list := h.Clients(uid)
+	for _, c := range list {
+		sendWS(c, payload)
+	}
+	return len(list)
+}
+
+// Broadcast 推送给所有在线连接
+func (h *Hub) Broadcast(payload any) {
+	h.mu.RLock()
+	list := make([]*Client, 0, len(h.byConn))
+	for _, c := range h.byConn {
+		list = append(list, c)
+	}
+	h.mu.RUnlock()
+
+	// 不持锁发送，避免慢连接拖住注册表
+	for _, c := range list {
+		sendWS(c, payload)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// ---------------------- 消息业务（HTTP 与 WS 共用） ----------------------
+
+// bizError 业务错误，Code/Msg 与 HTTP 返回的 code/msg 一致
+type bizError struct {
+	Code int
+	Msg  string
+}
+
+func (e *bizError) Error() string { return e.Msg }
+
+func newBizError(code int, msg string) *bizError { return &bizError{Code: code, Msg: msg} }
+
+type SendMessageReq struct {
+	SessionID  int    `json:"session_id"`
+	SendID     int    `json:"send_id"`
+	ReceiverID int    `json:"receiver_id"`
+	MsgType    int    `json:"msg_type"`
+	Content    string `json:"content"`
+	Nickname   string `json:"nickname"`
+	Avatar     string `json:"avatar"`
+
+	ClientMsgID string `json:"client_msg_id"` // 可选，客户端生成；重试时携带同一个值，服务端返回首次保存的消息
+	ReplyToID   int    `json:"reply_to_id"`   // 可选，引用回复同一会话内的消息
+
+	Payload json.RawMessage `json:"payload"` // 结构化内容，按 msg_type 校验；未传时由 content 转换
+
+	ObjectKey string `json:"-"` // 上传文件的对象 key，仅服务端设置
+}
+
+const clientMsgIDMaxLen = 64
+
+// clientMsgID 空值不落库（NULL 不参与唯一约束）
+func (req *SendMessageReq) clientMsgID() *string {
+	if req.ClientMsgID == "" {
+		return nil
+	}
+	id := req.ClientMsgID
+	return &id
+}
+
+// duplicateOf 按 client_msg_id 查找该发送者已保存的消息（客户端重试），没有返回 nil
+func duplicateOf(ctx context.Context, req *SendMessageReq) *TalkMessage {
+	if req.ClientMsgID == "" {
+		return nil
+	}
+	msg, err := store.Messages.FindByClientMsgID(ctx, req.SendID, req.ClientMsgID)
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+// sendMessage 校验、落库并推送一条消息；群聊时 receiver_id 可不传
+func sendMessage(ctx context.Context, req *SendMessageReq) (*TalkMessage, *bizError) {
+	if req.SessionID == 0 || req.SendID == 0 || req.MsgType == 0 {
+		return nil, newBizError(400, "参数错误")
+	}
+	// 按类型校验 payload，content 统一为预览文本
+	payload, preview, bizErr := buildPayload(req.MsgType, req.Content, req.Payload)
+	if bizErr != nil {
+		return nil, bizErr
+	}
+	req.Payload, req.Content = payload, preview
+	if len(req.ClientMsgID) > clientMsgIDMaxLen {
+		return nil, newBizError(400, "client_msg_id 过长")
+	}
+	// 重试：直接返回首次保存的消息，不重复落库和推送
+	if dup := duplicateOf(ctx, req); dup != nil {
+		return dup, nil
+	}
+	// —— 会话归属校验 —— //
+	sess, err := store.Sessions.Get(ctx, req.SessionID)
+	if err != nil {
+		return nil, newBizError(404, "会话不存在")
+	}
+	if sess.Type == SessionTypeGroup {
+		return sendGroupMessage(ctx, sess, req)
+	}
+
+	// 发送者必须在会话里
+	if req.SendID != sess.SendID && req.SendID != sess.ReceiverID {
+		return nil, newBizError(403, "你不在该会话中")
+	}
+	// 接收者必须是会话里除自己以外的那一方
+	expectedReceiver := sess.SendID
+	if req.SendID == sess.SendID {
+		expectedReceiver = sess.ReceiverID
+	}
+	if req.ReceiverID != expectedReceiver {
+		return nil, newBizError(400, "接收者与会话不匹配")
+	}
+	// 引用校验放在归属校验之后，非参与者无法据此探测消息属于哪个会话
+	quote, bizErr := loadQuote(ctx, sess.ID, req.ReplyToID)
+	if bizErr != nil {
+		return nil, bizErr
+	}
+	msg := &TalkMessage{
+		Sid:         req.SessionID,
+		SendID:      req.SendID,
+		ReceiverID:  req.ReceiverID,
+		MsgType:     req.MsgType,
+		Content:     req.Content,
+		Payload:     req.Payload,
+		Nickname:    req.Nickname,
+		Avatar:      req.Avatar,
+		IsRead:      0,
+		Status:      MsgStatusSent,
+		ClientMsgID: req.clientMsgID(),
+		ReplyToID:   req.ReplyToID,
+		ObjectKey:   req.ObjectKey,
+		Quote:       quote,
+	}
+	// 消息、会话、时间线与待推送事件在同一事务内写入
+	err = store.Tx(ctx, func(st *Stores) error {
+		if err := st.Messages.Create(ctx, msg); err != nil {
+			return err
+		}
+		// 更新会话最后消息 & 接收方一侧的未读（接收方显式已读时清零）
+		if err := st.Sessions.UpdateLastMessage(ctx, req.SessionID, req.Content, req.ReceiverID); err != nil {
+			return err
+		}
+		// 写入双方时间线，返回给发送方的是其自己的 seq
+		seqs, err := st.Messages.AppendTimeline(ctx, msg, []int{req.SendID, req.ReceiverID})
+		if err != nil {
+			return err
+		}
+		msg.Seq = seqs[req.SendID]
+		fresh, err := st.Sessions.Get(ctx, req.SessionID)
+		if err != nil {
+			return err
+		}
+
+		var batch outboxBatch
+		// 推送给接收方（在线设备收到后回 im.ack 确认送达）
+		batch.message(req.ReceiverID, msg, seqs)
+		// 推送最新会话信息给双方（所有设备），未读数按各自一侧填充
+		batch.push(req.SendID, sessionUpdatedFrame(fresh.viewedBy(req.SendID)))
+		batch.push(req.ReceiverID, sessionUpdatedFrame(fresh.viewedBy(req.ReceiverID)))
+		return batch.save(ctx, st)
+	})
+	if err != nil {
+		// 并发重试时唯一约束冲突，返回先提交的那条
+		if dup := duplicateOf(ctx, req); dup != nil {
+			return dup, nil
+		}
+		g.Log().Warningf(ctx, "send message failed, sid=%d: %v", req.SessionID, err)
+		return nil, newBizError(500, "保存消息失败")
+	}
+	outbox.kick()
+	indexMessage(ctx, msg)
+	return msg, nil
+}
+
+// checkParticipant uid 是否为会话参与者：单聊为双方，群聊为在群成员
+func checkParticipant(ctx context.Context, sid, uid int) *bizError {
+	sess, err := store.Sessions.Get(ctx, sid)
+	if err != nil {
+		return newBizError(404, "会话不存在")
+	}
+	if sess.Type == SessionTypeGroup {
+		if activeMember(ctx, sid, uid) == nil {
+			return newBizError(403, "你不在该会话中")
+		}
+		return nil
+	}
+	if uid == 0 || (uid != sess.SendID && uid != sess.ReceiverID) {
+		return newBizError(403, "你不在该会话中")
+	}
+	return nil
+}
+
+// sessionParticipants 会话参与者：单聊双方，群聊在群成员
+func sessionParticipants(ctx context.Context, st *Stores, sid int) ([]int, error) {
+	sess, err := st.Sessions.Get(ctx, sid)
+	if err != nil {
+		return nil, err
+	}
+	if sess.Type != SessionTypeGroup {
+		return []int{sess.SendID, sess.ReceiverID}, nil
+	}
+	rows, err := st.Sessions.Members(ctx, sid)
+	if err != nil {
+		return nil, err
+	}
+	uids := make([]int, 0, len(rows))
+	for _, m := range rows {
+		uids = append(uids, m.UserID)
+	}
+	return uids, nil
+}
+
+// messageParticipants 消息所在会话的参与者；未回填 sid 的历史消息为收发双方，与 canViewMessage 一致
+func messageParticipants(ctx context.Context, st *Stores, msg *TalkMessage) ([]int, error) {
+	if msg.Sid == 0 {
+		return []int{msg.SendID, msg.ReceiverID}, nil
+	}
+	return sessionParticipants(ctx, st, msg.Sid)
+}
+
+// sessionUpdated 把最新会话推给参与者，未读数按各自一侧/成员填充
+func (b *outboxBatch) sessionUpdated(ctx context.Context, st *Stores, sid int) error {
+	fresh, err := st.Sessions.Get(ctx, sid)
+	if err != nil {
+		return err
+	}
+	if fresh.Type != SessionTypeGroup {
+		b.push(fresh.SendID, sessionUpdatedFrame(fresh.viewedBy(fresh.SendID)))
+		b.push(fresh.ReceiverID, sessionUpdatedFrame(fresh.viewedBy(fresh.ReceiverID)))
+		return nil
+	}
+	rows, err := st.Sessions.Members(ctx, sid)
+	if err != nil {
+		return err
+	}
+	for _, m := range rows {
+		s := *fresh
+		s.UnReadNum = m.UnReadNum
+		b.push(m.UserID, sessionUpdatedFrame(s))
+	}
+	return nil
+}
+
+// sessionUpdatedFrame 组装 session_updated 推送帧，同一会话的未发出帧只保留最新一条
+func sessionUpdatedFrame(s TalkSession) any {
+	return coalesced(coalesceKey("session_updated", s.ID), map[string]any{"event": "session_updated", "data": s})
+}
+
+// messagePush 组装 im.message 推送帧，payload.url 为落库的未签名地址（由 outbox 投递时签名）
+func messagePush(msg *TalkMessage) map[string]any {
+	return map[string]any{
+		"event": "im.message",
+		"sid":   msg.Sid,
+		"seq":   msg.Seq, // 接收方的用户级序号，客户端据此增量同步
+		"content": map[string]any{
+			"data": map[string]any{
+				"id":          msg.ID,
+				"session_id":  msg.Sid,
+				"send_id":     msg.SendID,
+				"receiver_id": msg.ReceiverID,
+				"nickname":    msg.Nickname,
+				"avatar":      msg.Avatar,
+				"msg_type":    msg.MsgType,
+				"content":     msg.Content, // 文本正文或预览文本
+				"payload":     msg.Payload,
+				"created_at":  msg.CreatedAt.Format("2006-01-02 15:04:05"),
+				"is_read":     msg.IsRead,
+				"status":      msg.Status,
+				"seq":         msg.Seq,
+				"is_recalled": msg.IsRecalled,
+				"edited_at":   msg.EditedAt,
+				"reply_to_id": msg.ReplyToID,
+				"quote":       msg.Quote, // 被引用消息摘要，非回复时为 null
+			},
+			"receiver_id": msg.ReceiverID,
+			"send_id":     msg.SendID,
+		},
+	}
+}
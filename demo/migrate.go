@@ -0,0 +1,674 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"gorm.io/gorm"
+)
+
+// ---------------------- 版本化表结构迁移 ----------------------
+// 用法：
+//   go run . migrate status       查看已执行 / 待执行的版本
+//   go run . migrate up [n]       执行全部（或 n 个）待执行版本
+//   go run . migrate down [n]     回滚最近 1 个（或 n 个）版本
+// 每个版本在独立事务中执行并写入 schema_migrations；服务启动时若有待执行版本则拒绝启动。
+//
+// 迁移内使用当时的结构快照，不引用业务模型，避免模型后续变化改变历史迁移的含义。
+// 已存在的表/列/索引会跳过，兼容此前由 AutoMigrate 建出的库。
+
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false;column:version"`
+	Name      string    `gorm:"column:name;size:128"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+}
+
+func (SchemaMigration) TableName() string { return "schema_migrations" }
+
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// migrations 按版本号递增；已发布的版本不可修改，只能追加
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create_chat_tables",
+		Up: func(tx *gorm.DB) error {
+			type message struct {
+				ID         int `gorm:"primaryKey"`
+				Nickname   string
+				ReceiverID int
+				SendID     int
+				MsgType    int
+				Avatar     string
+				Content    string
+				IsRead     int
+				CreatedAt  time.Time `gorm:"autoCreateTime"`
+			}
+			type session struct {
+				ID         int `gorm:"primaryKey"`
+				ReceiverID int
+				IsOnline   int
+				Name       string
+				UnReadNum  int
+				MsgText    string
+				UpdatedAt  time.Time `gorm:"autoUpdateTime"`
+				SendID     int
+				Status     int `gorm:"default:1"`
+			}
+			type users struct {
+				ID         int `gorm:"primaryKey"`
+				Username   string
+				UserID     int
+				UserAvatar string
+			}
+			return createTables(tx, map[string]any{"message": &message{}, "session": &session{}, "users": &users{}})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("message", "session", "users")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add_message_sid_status",
+		Up: func(tx *gorm.DB) error {
+			return addColumns(tx, "message", &messageV2{}, "Sid", "Status")
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "message", &messageV2{}, "Sid", "Status")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_session_type",
+		Up: func(tx *gorm.DB) error {
+			return addColumns(tx, "session", &sessionV3{}, "Type")
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "session", &sessionV3{}, "Type")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_user_seq_timeline",
+		Up: func(tx *gorm.DB) error {
+			type userSeq struct {
+				UserID int `gorm:"primaryKey;autoIncrement:false"`
+				Seq    int64
+			}
+			type userTimeline struct {
+				ID        int64 `gorm:"primaryKey"`
+				UserID    int   `gorm:"uniqueIndex:uk_user_seq,priority:1"`
+				Seq       int64 `gorm:"uniqueIndex:uk_user_seq,priority:2"`
+				MessageID int
+				Sid       int
+				CreatedAt time.Time `gorm:"autoCreateTime"`
+			}
+			return createTables(tx, map[string]any{"user_seq": &userSeq{}, "user_timeline": &userTimeline{}})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("user_seq", "user_timeline")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_session_member",
+		Up: func(tx *gorm.DB) error {
+			type sessionMember struct {
+				ID        int `gorm:"primaryKey"`
+				Sid       int `gorm:"uniqueIndex:uk_sid_user,priority:1"`
+				UserID    int `gorm:"uniqueIndex:uk_sid_user,priority:2;index:idx_session_member_user_id"`
+				Role      int `gorm:"default:3"`
+				UnReadNum int
+				Status    int `gorm:"default:1"`
+				JoinedAt  time.Time
+				UpdatedAt time.Time `gorm:"autoUpdateTime"`
+			}
+			return createTables(tx, map[string]any{"session_member": &sessionMember{}})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("session_member")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_message_session_user_indexes",
+		Up: func(tx *gorm.DB) error {
+			// 未读查询 receiver_id+is_read；会话内游标分页 sid+id
+			type message struct {
+				ID         int `gorm:"index:idx_message_sid_id,priority:2"`
+				Sid        int `gorm:"index:idx_message_sid_id,priority:1"`
+				ReceiverID int `gorm:"index:idx_message_receiver_read,priority:1"`
+				IsRead     int `gorm:"index:idx_message_receiver_read,priority:2"`
+			}
+			type session struct {
+				SendID     int `gorm:"index:idx_session_send_receiver,priority:1"`
+				ReceiverID int `gorm:"index:idx_session_send_receiver,priority:2"`
+			}
+			type users struct {
+				UserID int `gorm:"index:idx_users_user_id"`
+			}
+			if err := createIndexes(tx, "message", &message{}, "idx_message_sid_id", "idx_message_receiver_read"); err != nil {
+				return err
+			}
+			if err := createIndexes(tx, "session", &session{}, "idx_session_send_receiver"); err != nil {
+				return err
+			}
+			return createIndexes(tx, "users", &users{}, "idx_users_user_id")
+		},
+		Down: func(tx *gorm.DB) error {
+			for table, names := range map[string][]string{
+				"message": {"idx_message_sid_id", "idx_message_receiver_read"},
+				"session": {"idx_session_send_receiver"},
+				"users":   {"idx_users_user_id"},
+			} {
+				for _, name := range names {
+					if tx.Migrator().HasIndex(table, name) {
+						if err := tx.Migrator().DropIndex(table, name); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 7,
+		Name:    "create_outbox",
+		Up: func(tx *gorm.DB) error {
+			type outbox struct {
+				ID         int64 `gorm:"primaryKey"`
+				UserID     int
+				Payload    string `gorm:"type:text"`
+				ClaimToken string `gorm:"size:64;default:'';index:idx_outbox_claim_token"`
+				ClaimedAt  *time.Time
+				Attempts   int
+				CreatedAt  time.Time `gorm:"autoCreateTime"`
+			}
+			return createTables(tx, map[string]any{"outbox": &outbox{}})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("outbox")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_message_client_msg_id",
+		Up: func(tx *gorm.DB) error {
+			if err := addColumns(tx, "message", &messageV8{}, "ClientMsgID"); err != nil {
+				return err
+			}
+			return createIndexes(tx, "message", &messageV8{}, "uk_message_sender_client")
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex("message", "uk_message_sender_client") {
+				if err := tx.Migrator().DropIndex("message", "uk_message_sender_client"); err != nil {
+					return err
+				}
+			}
+			return dropColumns(tx, "message", &messageV8{}, "ClientMsgID")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_message_recall_edit_hidden",
+		Up: func(tx *gorm.DB) error {
+			type messageEdit struct {
+				ID        int `gorm:"primaryKey"`
+				MessageID int `gorm:"index:idx_message_edit_message_id"`
+				EditorID  int
+				Content   string
+				CreatedAt time.Time `gorm:"autoCreateTime"`
+			}
+			type messageHidden struct {
+				ID        int       `gorm:"primaryKey"`
+				MessageID int       `gorm:"uniqueIndex:uk_message_hidden_user_msg,priority:2"`
+				UserID    int       `gorm:"uniqueIndex:uk_message_hidden_user_msg,priority:1"`
+				CreatedAt time.Time `gorm:"autoCreateTime"`
+			}
+			if err := addColumns(tx, "message", &messageV9{}, "IsRecalled", "EditedAt"); err != nil {
+				return err
+			}
+			return createTables(tx, map[string]any{"message_edit": &messageEdit{}, "message_hidden": &messageHidden{}})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable("message_edit", "message_hidden"); err != nil {
+				return err
+			}
+			return dropColumns(tx, "message", &messageV9{}, "IsRecalled", "EditedAt")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add_message_reply_to_id",
+		Up: func(tx *gorm.DB) error {
+			if err := addColumns(tx, "message", &messageV10{}, "ReplyToID"); err != nil {
+				return err
+			}
+			return createIndexes(tx, "message", &messageV10{}, "idx_message_reply_to_id")
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex("message", "idx_message_reply_to_id") {
+				if err := tx.Migrator().DropIndex("message", "idx_message_reply_to_id"); err != nil {
+					return err
+				}
+			}
+			return dropColumns(tx, "message", &messageV10{}, "ReplyToID")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "create_message_reaction",
+		Up: func(tx *gorm.DB) error {
+			type messageReaction struct {
+				ID        int       `gorm:"primaryKey"`
+				MessageID int       `gorm:"uniqueIndex:uk_message_reaction,priority:1"`
+				UserID    int       `gorm:"uniqueIndex:uk_message_reaction,priority:2"`
+				Emoji     string    `gorm:"size:32;uniqueIndex:uk_message_reaction,priority:3"`
+				CreatedAt time.Time `gorm:"autoCreateTime"`
+			}
+			return createTables(tx, map[string]any{"message_reaction": &messageReaction{}})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("message_reaction")
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_users_presence",
+		Up: func(tx *gorm.DB) error {
+			return addColumns(tx, "users", &usersV12{}, "Presence", "LastSeenAt")
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "users", &usersV12{}, "Presence", "LastSeenAt")
+		},
+	},
+	{
+		// 全文索引仅 MySQL 建立，其他驱动使用进程内索引（见 search.go）
+		Version: 13,
+		Name:    "add_message_fulltext",
+		Up: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() != "mysql" || tx.Migrator().HasIndex("message", "ft_message_content") {
+				return nil
+			}
+			return tx.Exec("ALTER TABLE message ADD FULLTEXT INDEX ft_message_content (content) WITH PARSER ngram").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() != "mysql" || !tx.Migrator().HasIndex("message", "ft_message_content") {
+				return nil
+			}
+			return tx.Migrator().DropIndex("message", "ft_message_content")
+		},
+	},
+	{
+		Version: 14,
+		Name:    "add_message_payload",
+		Up: func(tx *gorm.DB) error {
+			return addColumns(tx, "message", &messageV14{}, "Payload")
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "message", &messageV14{}, "Payload")
+		},
+	},
+	{
+		Version: 15,
+		Name:    "create_quote",
+		Up: func(tx *gorm.DB) error {
+			type quote struct {
+				ID         int `gorm:"primaryKey"`
+				MessageID  int `gorm:"index"`
+				SendID     int
+				ReceiverID int
+				Title      string `gorm:"size:64"`
+				Items      []byte `gorm:"type:text"`
+				Currency   string `gorm:"size:8"`
+				Total      int64
+				Note       string `gorm:"size:500"`
+				State      string `gorm:"size:16"`
+				Counter    []byte `gorm:"type:text"`
+				ExpiresAt  time.Time
+				CreatedAt  time.Time `gorm:"autoCreateTime"`
+				UpdatedAt  time.Time `gorm:"autoUpdateTime"`
+			}
+			type quoteAction struct {
+				ID        int `gorm:"primaryKey"`
+				QuoteID   int `gorm:"index"`
+				ActorID   int
+				Action    string    `gorm:"size:16"`
+				FromState string    `gorm:"size:16"`
+				ToState   string    `gorm:"size:16"`
+				Detail    []byte    `gorm:"type:text"`
+				CreatedAt time.Time `gorm:"autoCreateTime"`
+			}
+			return createTables(tx, map[string]any{"quote": &quote{}, "quote_action": &quoteAction{}})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("quote_action", "quote")
+		},
+	},
+	{
+		Version: 16,
+		Name:    "create_upload_session",
+		Up: func(tx *gorm.DB) error {
+			type uploadSession struct {
+				ID         string `gorm:"primaryKey;size:32"`
+				UserID     int    `gorm:"index"`
+				SessionID  int
+				ReceiverID int
+				MsgType    int
+				Name       string `gorm:"size:255"`
+				Mime       string `gorm:"size:128"`
+				Size       int64
+				ChunkSize  int64
+				ChunkCount int
+				Sha256     string `gorm:"size:64"`
+				Nickname   string `gorm:"size:64"`
+				Avatar     string `gorm:"size:255"`
+				State      string `gorm:"size:16"`
+				MessageID  int
+				ExpiresAt  time.Time `gorm:"index"`
+				CreatedAt  time.Time `gorm:"autoCreateTime"`
+				UpdatedAt  time.Time `gorm:"autoUpdateTime"`
+			}
+			type uploadChunk struct {
+				ID         int    `gorm:"primaryKey"`
+				UploadID   string `gorm:"size:32;uniqueIndex:uk_upload_chunk,priority:1"`
+				ChunkIndex int    `gorm:"uniqueIndex:uk_upload_chunk,priority:2"`
+				Size       int64
+				Sha256     string    `gorm:"size:64"`
+				CreatedAt  time.Time `gorm:"autoCreateTime"`
+			}
+			return createTables(tx, map[string]any{"upload_session": &uploadSession{}, "upload_chunk": &uploadChunk{}})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("upload_chunk", "upload_session")
+		},
+	},
+	{
+		Version: 17,
+		Name:    "add_message_object_key",
+		Up: func(tx *gorm.DB) error {
+			return addColumns(tx, "message", &messageV17{}, "ObjectKey")
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "message", &messageV17{}, "ObjectKey")
+		},
+	},
+	{
+		Version: 18,
+		Name:    "add_session_send_unread",
+		Up: func(tx *gorm.DB) error {
+			return addColumns(tx, "session", &sessionV18{}, "SendUnReadNum")
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "session", &sessionV18{}, "SendUnReadNum")
+		},
+	},
+	{
+		Version: 19,
+		Name:    "add_outbox_coalesce_key",
+		Up: func(tx *gorm.DB) error {
+			return addColumns(tx, "outbox", &outboxV19{}, "Key")
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "outbox", &outboxV19{}, "Key")
+		},
+	},
+	{
+		Version: 20,
+		Name:    "add_outbox_object_key",
+		Up: func(tx *gorm.DB) error {
+			return addColumns(tx, "outbox", &outboxV20{}, "ObjectKey")
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, "outbox", &outboxV20{}, "ObjectKey")
+		},
+	},
+}
+
+// 新增列的结构快照
+type messageV2 struct {
+	Sid    int
+	Status int `gorm:"default:1"`
+}
+
+type sessionV3 struct {
+	Type int `gorm:"default:1"`
+}
+
+type messageV8 struct {
+	SendID      int     `gorm:"uniqueIndex:uk_message_sender_client,priority:1"`
+	ClientMsgID *string `gorm:"size:64;uniqueIndex:uk_message_sender_client,priority:2"`
+}
+
+type messageV9 struct {
+	IsRecalled int
+	EditedAt   *time.Time
+}
+
+type messageV10 struct {
+	ReplyToID int `gorm:"index:idx_message_reply_to_id"`
+}
+
+type usersV12 struct {
+	Presence   string `gorm:"size:16;default:offline"`
+	LastSeenAt *time.Time
+}
+
+type messageV14 struct {
+	Payload []byte `gorm:"type:text"`
+}
+
+type messageV17 struct {
+	ObjectKey string `gorm:"size:255"`
+}
+
+type sessionV18 struct {
+	SendUnReadNum int `gorm:"column:send_un_read_num"`
+}
+
+type outboxV19 struct {
+	Key string `gorm:"column:coalesce_key;size:64;default:''"`
+}
+
+type outboxV20 struct {
+	ObjectKey string `gorm:"column:object_key;size:255;default:''"`
+}
+
+// ---------------------- 迁移辅助 ----------------------
+
+func createTables(tx *gorm.DB, tables map[string]any) error {
+	for name, model := range tables {
+		if tx.Migrator().HasTable(name) {
+			continue
+		}
+		if err := tx.Table(name).Migrator().CreateTable(model); err != nil {
+			return fmt.Errorf("create table %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func addColumns(tx *gorm.DB, table string, model any, fields ...string) error {
+	m := tx.Table(table).Migrator()
+	for _, f := range fields {
+		if m.HasColumn(model, f) {
+			continue
+		}
+		if err := m.AddColumn(model, f); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", table, f, err)
+		}
+	}
+	return nil
+}
+
+func dropColumns(tx *gorm.DB, table string, model any, fields ...string) error {
+	m := tx.Table(table).Migrator()
+	for _, f := range fields {
+		if !m.HasColumn(model, f) {
+			continue
+		}
+		if err := m.DropColumn(model, f); err != nil {
+			return fmt.Errorf("drop column %s.%s: %w", table, f, err)
+		}
+	}
+	return nil
+}
+
+func createIndexes(tx *gorm.DB, table string, model any, names ...string) error {
+	m := tx.Table(table).Migrator()
+	for _, name := range names {
+		if m.HasIndex(model, name) {
+			continue
+		}
+		if err := m.CreateIndex(model, name); err != nil {
+			return fmt.Errorf("create index %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ---------------------- 执行 ----------------------
+
+func appliedVersions() (map[int]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := db.Order("version asc").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, r := range rows {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+func pendingMigrations() ([]migration, error) {
+	applied, err := appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+	var pending []migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// migrateUp 执行待执行版本，limit<=0 表示全部
+func migrateUp(ctx context.Context, limit int) error {
+	pending, err := pendingMigrations()
+	if err != nil {
+		return err
+	}
+	for i, m := range pending {
+		if limit > 0 && i >= limit {
+			break
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migrate up %d_%s: %w", m.Version, m.Name, err)
+		}
+		g.Log().Infof(ctx, "migrated up: %d_%s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// migrateDown 按版本倒序回滚 n 个已执行版本
+func migrateDown(ctx context.Context, n int) error {
+	applied, err := appliedVersions()
+	if err != nil {
+		return err
+	}
+	if n <= 0 {
+		n = 1
+	}
+	for i := len(migrations) - 1; i >= 0 && n > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, "version=?", m.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migrate down %d_%s: %w", m.Version, m.Name, err)
+		}
+		g.Log().Infof(ctx, "migrated down: %d_%s", m.Version, m.Name)
+		n--
+	}
+	return nil
+}
+
+func migrateStatus(ctx context.Context) error {
+	applied, err := appliedVersions()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if r, ok := applied[m.Version]; ok {
+			g.Log().Infof(ctx, "[applied %s] %d_%s", r.AppliedAt.Format("2006-01-02 15:04:05"), m.Version, m.Name)
+		} else {
+			g.Log().Infof(ctx, "[pending] %d_%s", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// runMigrate 处理 migrate 子命令：migrate [up|down|status] [n]
+func runMigrate(ctx context.Context, args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	n := 0
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid step count: %q", args[1])
+		}
+		n = v
+	}
+	switch action {
+	case "up":
+		return migrateUp(ctx, n)
+	case "down":
+		return migrateDown(ctx, n)
+	case "status":
+		return migrateStatus(ctx)
+	default:
+		return fmt.Errorf("unknown migrate action: %q (up|down|status)", action)
+	}
+}
+
+// ensureMigrated 服务启动前检查表结构版本，有待执行版本时拒绝启动
+func ensureMigrated(ctx context.Context) {
+	pending, err := pendingMigrations()
+	if err != nil {
+		g.Log().Fatalf(ctx, "check schema migrations failed: %v", err)
+	}
+	if len(pending) > 0 {
+		g.Log().Fatalf(ctx, "%d pending schema migration(s) starting at %d_%s, run \"migrate up\" first",
+			len(pending), pending[0].Version, pending[0].Name)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// ---------------------- 消息类型注册表 ----------------------
+// 每种 msg_type 声明：
+//   - payload 字段（生成 JSON Schema 并做校验，未声明的字段丢弃）
+//   - 预览文本：写入 message.content 与会话 msg_text，引用/搜索/旧客户端都读它
+//   - 渲染元数据：客户端据此选择展示组件
+// 消息结构化内容存 message.payload（JSON）。文本消息的 content 即正文。
+// 旧客户端只传 content 时，按类型的 fromContent 转成 payload（文本为正文，媒体为 URL）。
+// GET /talk/message/types 返回全部类型的 schema 与渲染元数据。
+
+const (
+	// MsgTypeText = 1 见 recall.go
+	MsgTypeImage    = 2
+	MsgTypeFile     = 3
+	MsgTypeVoice    = 4
+	MsgTypeVideo    = 5
+	MsgTypeLocation = 6
+	// MsgTypeLegacyReview 旧版 /talk/review 生成的复核卡片（content 固定为 "[复核报价]"，无 payload），
+	// 保留该编号仅供历史消息渲染，只由服务端生成，不得复用
+	MsgTypeLegacyReview = 1000
+	// MsgTypeQuote = 1001 复核报价卡片，见 quote.go
+	MsgTypeCard = 1002 // 通用卡片
+)
+
+// payload 字段类型
+const (
+	fieldString  = "string"
+	fieldURL     = "url" // 站内路径（/ 开头）或 http(s) 地址
+	fieldInteger = "integer"
+	fieldNumber  = "number"
+	fieldObject  = "object"
+	fieldArray   = "array"
+)
+
+type payloadField struct {
+	Name     string
+	Kind     string
+	Required bool
+	MaxLen   int // 字符串最大字符数，0 不限
+	Desc     string
+}
+
+// RenderMeta 客户端渲染元数据
+type RenderMeta struct {
+	Component string `json:"component"` // 展示组件：text / image / file / voice / video / location / card
+	Bubble    bool   `json:"bubble"`    // 是否使用气泡
+	Icon      string `json:"icon"`      // 会话列表/引用中的图标
+}
+
+type MessageType struct {
+	Code       int
+	Name       string
+	Fields     []payloadField
+	Render     RenderMeta
+	Editable   bool // 发送者可编辑
+	Searchable bool // 纳入全文搜索
+	System     bool // 仅由服务端生成，客户端不能通过发送接口发送
+
+	check       func(p map[string]any) error        // 字段之外的校验，可为空
+	preview     func(p map[string]any) string       // 预览文本
+	fromContent func(content string) map[string]any // 旧客户端只传 content 时的转换，为空表示必须传 payload
+}
+
+var messageTypes = map[int]*MessageType{}
+
+func registerMessageType(t *MessageType) {
+	if _, ok := messageTypes[t.Code]; ok {
+		panic(fmt.Sprintf("duplicate msg_type %d", t.Code))
+	}
+	messageTypes[t.Code] = t
+}
+
+func messageTypeOf(code int) *MessageType {
+	return messageTypes[code]
+}
+
+// Schema 按字段生成 JSON Schema
+func (t *MessageType) Schema() map[string]any {
+	props := make(map[string]any, len(t.Fields))
+	required := make([]string, 0)
+	for _, f := range t.Fields {
+		prop := map[string]any{"type": f.Kind}
+		if f.Desc != "" {
+			prop["description"] = f.Desc
+		}
+		if f.Kind == fieldURL {
+			prop["type"] = fieldString
+			prop["format"] = "uri-reference"
+		}
+		if f.MaxLen > 0 {
+			prop["maxLength"] = f.MaxLen
+		}
+		props[f.Name] = prop
+		if f.Required {
+			required = append(required, f.Name)
+		}
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func validField(f payloadField, v any) error {
+	switch f.Kind {
+	case fieldString, fieldURL:
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s 应为字符串", f.Name)
+		}
+		if f.Required && strings.TrimSpace(s) == "" {
+			return fmt.Errorf("%s 不能为空", f.Name)
+		}
+		if f.MaxLen > 0 && utf8.RuneCountInString(s) > f.MaxLen {
+			return fmt.Errorf("%s 过长", f.Name)
+		}
+		if f.Kind == fieldURL && s != "" && !strings.HasPrefix(s, "/") &&
+			!strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+			return fmt.Errorf("%s 不是有效地址", f.Name)
+		}
+	case fieldInteger:
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) || n < 0 {
+			return fmt.Errorf("%s 应为非负整数", f.Name)
+		}
+	case fieldNumber:
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s 应为数字", f.Name)
+		}
+	case fieldObject:
+		if _, ok := v.(map[string]any); !ok {
+			return fmt.Errorf("%s 应为对象", f.Name)
+		}
+	case fieldArray:
+		if _, ok := v.([]any); !ok {
+			return fmt.Errorf("%s 应为数组", f.Name)
+		}
+	}
+	return nil
+}
+
+// Normalize 校验 payload，返回只含声明字段的 payload 与预览文本
+func (t *MessageType) Normalize(raw map[string]any) (map[string]any, string, error) {
+	p := make(map[string]any, len(t.Fields))
+	for _, f := range t.Fields {
+		v, ok := raw[f.Name]
+		if !ok || v == nil {
+			if f.Required {
+				return nil, "", fmt.Errorf("缺少 %s", f.Name)
+			}
+			continue
+		}
+		if err := validField(f, v); err != nil {
+			return nil, "", err
+		}
+		p[f.Name] = v
+	}
+	if t.check != nil {
+		if err := t.check(p); err != nil {
+			return nil, "", err
+		}
+	}
+	return p, t.preview(p), nil
+}
+
+// buildPayload 按类型校验请求中的 payload（未传时由 content 转换），返回 payload JSON 与预览文本
+func buildPayload(msgType int, content string, raw json.RawMessage) (json.RawMessage, string, *bizError) {
+	t := messageTypeOf(msgType)
+	if t == nil {
+		return nil, "", newBizError(400, "不支持的消息类型")
+	}
+	if t.System {
+		return nil, "", newBizError(400, "该类型消息不能直接发送")
+	}
+	var p map[string]any
+	switch {
+	case len(raw) > 0 && string(raw) != "null":
+		if err := json.Unmarshal(raw, &p); err != nil || p == nil {
+			return nil, "", newBizError(400, "payload 格式错误")
+		}
+	case content != "" && t.fromContent != nil:
+		p = t.fromContent(content)
+	default:
+		return nil, "", newBizError(400, "参数错误")
+	}
+	p, preview, err := t.Normalize(p)
+	if err != nil {
+		return nil, "", newBizError(400, err.Error())
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, "", newBizError(400, "payload 格式错误")
+	}
+	return data, preview, nil
+}
+
+func payloadString(p map[string]any, key string) string {
+	s, _ := p[key].(string)
+	return s
+}
+
+// withName 预览文本附带名称，如 "[文件] 报价单.pdf"
+func withName(tag, name string) string {
+	if name == "" {
+		return tag
+	}
+	return tag + " " + name
+}
+
+func urlContent(content string) map[string]any {
+	return map[string]any{"url": content}
+}
+
+func init() {
+	registerMessageType(&MessageType{
+		Code: MsgTypeText,
+		Name: "text",
+		Fields: []payloadField{
+			{Name: "text", Kind: fieldString, Required: true, MaxLen: 5000, Desc: "正文"},
+		},
+		Render:      RenderMeta{Component: "text", Bubble: true},
+		Editable:    true,
+		Searchable:  true,
+		preview:     func(p map[string]any) string { return payloadString(p, "text") },
+		fromContent: func(content string) map[string]any { return map[string]any{"text": content} },
+	})
+	registerMessageType(&MessageType{
+		Code: MsgTypeImage,
+		Name: "image",
+		Fields: []payloadField{
+			{Name: "url", Kind: fieldURL, Required: true, MaxLen: 1024, Desc: "原图地址"},
+			{Name: "thumbnail", Kind: fieldURL, MaxLen: 1024, Desc: "缩略图地址"},
+			{Name: "width", Kind: fieldInteger, Desc: "宽（px）"},
+			{Name: "height", Kind: fieldInteger, Desc: "高（px）"},
+			{Name: "size", Kind: fieldInteger, Desc: "字节数"},
+			{Name: "mime", Kind: fieldString, MaxLen: 128},
+		},
+		Render:      RenderMeta{Component: "image", Icon: "image"},
+		preview:     func(map[string]any) string { return "[图片]" },
+		fromContent: urlContent,
+	})
+	registerMessageType(&MessageType{
+		Code: MsgTypeFile,
+		Name: "file",
+		Fields: []payloadField{
+			{Name: "url", Kind: fieldURL, Required: true, MaxLen: 1024, Desc: "下载地址"},
+			{Name: "name", Kind: fieldString, MaxLen: 255, Desc: "文件名"},
+			{Name: "size", Kind: fieldInteger, Desc: "字节数"},
+			{Name: "mime", Kind: fieldString, MaxLen: 128},
+		},
+		Render:  RenderMeta{Component: "file", Bubble: true, Icon: "file"},
+		preview: func(p map[string]any) string { return withName("[文件]", payloadString(p, "name")) },
+		fromContent: func(content string) map[string]any {
+			return map[string]any{"url": content, "name": path.Base(content)}
+		},
+	})
+	registerMessageType(&MessageType{
+		Code: MsgTypeVoice,
+		Name: "voice",
+		Fields: []payloadField{
+			{Name: "url", Kind: fieldURL, Required: true, MaxLen: 1024, Desc: "音频地址"},
+			{Name: "duration", Kind: fieldInteger, Desc: "时长（秒）"},
+			{Name: "size", Kind: fieldInteger, Desc: "字节数"},
+			{Name: "mime", Kind: fieldString, MaxLen: 128},
+		},
+		Render: RenderMeta{Component: "voice", Bubble: true, Icon: "voice"},
+		preview: func(p map[string]any) string {
+			if d, ok := p["duration"].(float64); ok && d > 0 {
+				return fmt.Sprintf("[语音] %d\"", int(d))
+			}
+			return "[语音]"
+		},
+		fromContent: urlContent,
+	})
+	registerMessageType(&MessageType{
+		Code: MsgTypeVideo,
+		Name: "video",
+		Fields: []payloadField{
+			{Name: "url", Kind: fieldURL, Required: true, MaxLen: 1024, Desc: "视频地址"},
+			{Name: "cover", Kind: fieldURL, MaxLen: 1024, Desc: "封面地址"},
+			{Name: "duration", Kind: fieldInteger, Desc: "时长（秒）"},
+			{Name: "width", Kind: fieldInteger},
+			{Name: "height", Kind: fieldInteger},
+			{Name: "size", Kind: fieldInteger, Desc: "字节数"},
+			{Name: "mime", Kind: fieldString, MaxLen: 128},
+		},
+		Render:      RenderMeta{Component: "video", Icon: "video"},
+		preview:     func(map[string]any) string { return "[视频]" },
+		fromContent: urlContent,
+	})
+	registerMessageType(&MessageType{
+		Code: MsgTypeLocation,
+		Name: "location",
+		Fields: []payloadField{
+			{Name: "latitude", Kind: fieldNumber, Required: true, Desc: "纬度 -90~90"},
+			{Name: "longitude", Kind: fieldNumber, Required: true, Desc: "经度 -180~180"},
+			{Name: "name", Kind: fieldString, MaxLen: 128, Desc: "地点名称"},
+			{Name: "address", Kind: fieldString, MaxLen: 255, Desc: "详细地址"},
+		},
+		Render: RenderMeta{Component: "location", Bubble: true, Icon: "location"},
+		check: func(p map[string]any) error {
+			lat, lng := p["latitude"].(float64), p["longitude"].(float64)
+			if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+				return fmt.Errorf("经纬度超出范围")
+			}
+			return nil
+		},
+		preview: func(p map[string]any) string { return withName("[位置]", payloadString(p, "name")) },
+	})
+	registerMessageType(&MessageType{
+		Code: MsgTypeCard,
+		Name: "card",
+		Fields: []payloadField{
+			{Name: "title", Kind: fieldString, Required: true, MaxLen: 64, Desc: "标题"},
+			{Name: "description", Kind: fieldString, MaxLen: 500, Desc: "描述"},
+			{Name: "url", Kind: fieldURL, MaxLen: 1024, Desc: "点击跳转地址"},
+			{Name: "extra", Kind: fieldObject, Desc: "业务自定义数据"},
+		},
+		Render:  RenderMeta{Component: "card", Icon: "card"},
+		preview: func(p map[string]any) string { return "[" + payloadString(p, "title") + "]" },
+	})
+	registerMessageType(&MessageType{
+		Code:    MsgTypeLegacyReview,
+		Name:    "review_legacy",
+		Render:  RenderMeta{Component: "review_card", Icon: "quote"},
+		System:  true,
+		preview: func(map[string]any) string { return "[复核报价]" },
+	})
+}
+
+// 消息类型列表（schema 与渲染元数据）
+// GET /talk/message/types
+func messageTypesHandler(r *ghttp.Request) {
+	codes := make([]int, 0, len(messageTypes))
+	for code := range messageTypes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	list := make([]g.Map, 0, len(codes))
+	for _, code := range codes {
+		t := messageTypes[code]
+		list = append(list, g.Map{
+			"msg_type":   t.Code,
+			"name":       t.Name,
+			"schema":     t.Schema(),
+			"render":     t.Render,
+			"editable":   t.Editable,
+			"searchable": t.Searchable,
+			"system":     t.System,
+		})
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": list})
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLegacyReviewTypeReserved(t *testing.T) {
+	if _, _, bizErr := buildPayload(MsgTypeLegacyReview, "[复核报价]", nil); bizErr == nil || bizErr.Code != 400 {
+		t.Fatalf("legacy review card must be server-only, got %+v", bizErr)
+	}
+	if lt := messageTypeOf(MsgTypeLegacyReview); lt == nil || !lt.System || lt.Name != "review_legacy" {
+		t.Fatalf("legacy review type = %+v", lt)
+	}
+	raw := json.RawMessage(`{"title":"订单","url":"/orders/1"}`)
+	if _, preview, bizErr := buildPayload(MsgTypeCard, "", raw); bizErr != nil || preview != "[订单]" {
+		t.Fatalf("card: preview=%q err=%+v", preview, bizErr)
+	}
+}
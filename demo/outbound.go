@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+	"github.com/gorilla/websocket"
+)
+
+// ---------------------- 出站队列 & 慢消费者策略 ----------------------
+// 每个连接一个出站队列，只有 writePump 消费：
+//   - 低优先级事件（在线状态、会话更新、正在输入）由产生处用 coalesced 附带合并 key，
+//     同 key 的帧在队列里合并，队列超过 highWater 后直接丢弃
+//   - 其余事件超过 maxQueue 判定为慢消费者，关闭连接
+// 合并 key 随 outbox 记录与跨节点信封一起传递，投递时不再解析帧内容。
+// 连接只通过 Client.Close 关闭（sync.Once），不再由发送方关闭 channel。
+
+var (
+	queueHighWater = 256
+	queueMaxSize   = 1024
+)
+
+// 出站统计
+var wsStats struct {
+	framesSent      atomic.Int64
+	framesDropped   atomic.Int64
+	framesCoalesced atomic.Int64
+	slowConsumers   atomic.Int64
+}
+
+func initOutbound(ctx context.Context) {
+	queueHighWater = g.Cfg().MustGet(ctx, "websocket.sendQueue.highWater", queueHighWater).Int()
+	queueMaxSize = g.Cfg().MustGet(ctx, "websocket.sendQueue.maxQueue", queueMaxSize).Int()
+	if queueMaxSize < queueHighWater {
+		queueMaxSize = queueHighWater
+	}
+}
+
+type outFrame struct {
+	data []byte
+	key  string // 合并 key，为空表示不可合并
+}
+
+type sendQueue struct {
+	mu      sync.Mutex
+	frames  []outFrame
+	closed  bool
+	notify  chan struct{} // 有新帧时通知 writePump
+	dropped int64         // 本连接丢弃的帧数
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{notify: make(chan struct{}, 1)}
+}
+
+// push 入队结果
+const (
+	pushQueued = iota
+	pushCoalesced
+	pushDropped
+	pushOverflow // 超过 maxQueue，需断开
+	pushClosed
+)
+
+func (q *sendQueue) push(f outFrame) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return pushClosed
+	}
+	if f.key != "" {
+		// 同 key 的帧尚未发出：原地替换为最新内容
+		for i := range q.frames {
+			if q.frames[i].key == f.key {
+				q.frames[i].data = f.data
+				return pushCoalesced
+			}
+		}
+		if len(q.frames) >= queueHighWater {
+			q.dropped++
+			return pushDropped
+		}
+	} else if len(q.frames) >= queueMaxSize {
+		return pushOverflow
+	}
+
+	q.frames = append(q.frames, f)
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return pushQueued
+}
+
+// drain 取出当前所有待发送帧
+func (q *sendQueue) drain() [][]byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := make([][]byte, 0, len(q.frames))
+	for _, f := range q.frames {
+		out = append(out, f.data)
+	}
+	q.frames = q.frames[:0]
+	return out
+}
+
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.frames = nil
+	q.mu.Unlock()
+}
+
+func (q *sendQueue) stats() (pending int, dropped int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.frames), q.dropped
+}
+
+// keyedPayload 带合并 key 的低优先级推送
+type keyedPayload struct {
+	key     string
+	payload any
+}
+
+// coalesced 把 payload 标记为低优先级，key 相同的未发出帧只保留最新一条；key 为空时原样返回
+func coalesced(key string, payload any) any {
+	if key == "" {
+		return payload
+	}
+	return keyedPayload{key: key, payload: payload}
+}
+
+// coalesceKey 合并 key：事件名 + 标识，如 session_updated:1001、typing:1001:5
+func coalesceKey(event string, ids ...int) string {
+	key := event
+	for _, id := range ids {
+		key += ":" + strconv.Itoa(id)
+	}
+	return key
+}
+
+// splitPayload 拆出合并 key 与实际推送内容
+func splitPayload(payload any) (string, any) {
+	if k, ok := payload.(keyedPayload); ok {
+		return k.key, k.payload
+	}
+	return "", payload
+}
+
+// ---------------------- 发送 ----------------------
+func sendWS(c *Client, payload any) {
+	key, payload := splitPayload(payload)
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	switch c.queue.push(outFrame{data: data, key: key}) {
+	case pushCoalesced:
+		wsStats.framesCoalesced.Add(1)
+	case pushDropped:
+		wsStats.framesDropped.Add(1)
+	case pushOverflow:
+		// 下游阻塞，判定为慢消费者
+		wsStats.framesDropped.Add(1)
+		wsStats.slowConsumers.Add(1)
+		c.Close("slow consumer")
+	}
+}
+
+// Close 关闭连接（可重复调用，只生效一次）；readPump 随之退出并从 hub 注销
+func (c *Client) Close(reason string) {
+	c.closeOnce.Do(func() {
+		c.queue.close()
+		close(c.done)
+		_ = c.Conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason), time.Now().Add(time.Second))
+		_ = c.Conn.Close()
+	})
+}
+
+func writePump(c *Client) {
+	ticker := time.NewTicker(c.Heartbeat)
+	defer ticker.Stop()
+	defer c.Close("write closed")
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.queue.notify:
+			for _, msg := range c.queue.drain() {
+				_ = c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+					return
+				}
+				wsStats.framesSent.Add(1)
+			}
+		case <-ticker.C:
+			_ = c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+			// 心跳 2 倍未收到 pong 认为断开
+			if time.Since(c.LastPong) > c.Heartbeat*2 {
+				return
+			}
+		}
+	}
+}
+
+// 出站统计
+// GET /metrics/ws
+func wsMetricsHandler(r *ghttp.Request) {
+	var pending, dropped int64
+	conns := 0
+	for _, uid := range hub.OnlineUserIDs() {
+		for _, c := range hub.Clients(uid) {
+			p, d := c.queue.stats()
+			pending += int64(p)
+			dropped += d
+			conns++
+		}
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": g.Map{
+		"connections":      conns,
+		"queued_frames":    pending,
+		"conn_dropped":     dropped, // 当前在线连接累计丢弃
+		"frames_sent":      wsStats.framesSent.Load(),
+		"frames_dropped":   wsStats.framesDropped.Load(),
+		"frames_coalesced": wsStats.framesCoalesced.Load(),
+		"slow_consumers":   wsStats.slowConsumers.Load(),
+		"queue_high_water": queueHighWater,
+		"queue_max_size":   queueMaxSize,
+	}})
+}
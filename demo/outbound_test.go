@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSendQueueCoalesce(t *testing.T) {
+	q := newSendQueue()
+	push := func(payload any) int {
+		key, payload := splitPayload(payload)
+		data, _ := json.Marshal(payload)
+		return q.push(outFrame{data: data, key: key})
+	}
+	frame := func(unread int) any {
+		return sessionUpdatedFrame(TalkSession{ID: 7, UnReadNum: unread})
+	}
+
+	if got := push(frame(1)); got != pushQueued {
+		t.Fatalf("first session_updated: %d, want queued", got)
+	}
+	if got := push(map[string]any{"event": "im.message"}); got != pushQueued {
+		t.Fatalf("im.message: %d, want queued", got)
+	}
+	if got := push(frame(2)); got != pushCoalesced {
+		t.Fatalf("second session_updated: %d, want coalesced", got)
+	}
+	// 不带 key 的同名事件不合并
+	if got := push(map[string]any{"event": "im.message"}); got != pushQueued {
+		t.Fatalf("second im.message: %d, want queued", got)
+	}
+
+	frames := q.drain()
+	if len(frames) != 3 {
+		t.Fatalf("drained %d frames, want 3", len(frames))
+	}
+	var first struct {
+		Data TalkSession `json:"data"`
+	}
+	if err := json.Unmarshal(frames[0], &first); err != nil || first.Data.UnReadNum != 2 {
+		t.Fatalf("coalesced frame = %s, want latest un_read_num 2", frames[0])
+	}
+}
+
+func TestOutboxBatchKeepsCoalesceKey(t *testing.T) {
+	var b outboxBatch
+	b.push(1, sessionUpdatedFrame(TalkSession{ID: 7}))
+	b.push(1, map[string]any{"event": "im.message"})
+	if len(b.entries) != 2 {
+		t.Fatalf("entries = %d, want 2", len(b.entries))
+	}
+	if b.entries[0].Key != "session_updated:7" || b.entries[1].Key != "" {
+		t.Fatalf("keys = %q, %q", b.entries[0].Key, b.entries[1].Key)
+	}
+	var head struct {
+		Event string `json:"event"`
+	}
+	if err := json.Unmarshal([]byte(b.entries[0].Payload), &head); err != nil || head.Event != "session_updated" {
+		t.Fatalf("payload = %s", b.entries[0].Payload)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// ---------------------- Outbox：事务内记录推送，提交后投递 ----------------------
+// 发消息 / 上传 / 复核报价的落库与待推送事件写在同一事务里：
+//   - 事务提交后立即唤醒 dispatcher 投递，正常情况下与直接推送延迟相当
+//   - 进程在提交与推送之间崩溃时，记录仍在 outbox 表中，由任一节点的 dispatcher 轮询补投
+// 投递 = cluster.Push（本机设备 + 跨节点），投递完成即删除记录。
+// 补投可能导致客户端收到重复帧，客户端按消息 id / seq 去重。
+
+type OutboxEntry struct {
+	ID         int64      `gorm:"primaryKey;column:id" json:"id"`
+	UserID     int        `gorm:"column:user_id" json:"user_id"`
+	Payload    string     `gorm:"column:payload;type:text" json:"payload"`                 // 推送帧 JSON
+	Key        string     `gorm:"column:coalesce_key;size:64;default:''" json:"key"`       // 低优先级事件的合并 key
+	ObjectKey  string     `gorm:"column:object_key;size:255;default:''" json:"object_key"` // im.message 帧中文件的对象 key，投递时签名
+	ClaimToken string     `gorm:"column:claim_token;size:64;default:'';index" json:"claim_token"`
+	ClaimedAt  *time.Time `gorm:"column:claimed_at" json:"claimed_at"`
+	Attempts   int        `gorm:"column:attempts" json:"attempts"`
+	CreatedAt  time.Time  `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (OutboxEntry) TableName() string { return "outbox" }
+
+// outboxBatch 在事务内收集待推送事件，随事务一起写入 outbox
+type outboxBatch struct {
+	entries []OutboxEntry
+}
+
+func (b *outboxBatch) push(uid int, payload any) {
+	if uid == 0 {
+		return
+	}
+	key, payload := splitPayload(payload)
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	b.entries = append(b.entries, OutboxEntry{UserID: uid, Payload: string(data), Key: key})
+}
+
+// message 推送 im.message，附带接收方的 seq；文件地址不在这里签名，投递时再签，避免补投时已过期
+func (b *outboxBatch) message(uid int, msg *TalkMessage, seqs map[int]int64) {
+	m := *msg
+	m.Seq = seqs[uid]
+	n := len(b.entries)
+	b.push(uid, messagePush(&m))
+	if len(b.entries) > n && m.IsRecalled == 0 {
+		b.entries[n].ObjectKey = m.ObjectKey
+	}
+}
+
+func (b *outboxBatch) save(ctx context.Context, st *Stores) error {
+	return st.Outbox.Enqueue(ctx, b.entries)
+}
+
+// ---------------------- Dispatcher ----------------------
+
+type outboxDispatcher struct {
+	wake       chan struct{}
+	interval   time.Duration // 轮询间隔（兜底补投）
+	batchSize  int
+	staleAfter time.Duration // 认领后超过该时长未完成视为认领者已崩溃，可被重新认领
+}
+
+var outbox = &outboxDispatcher{
+	wake:       make(chan struct{}, 1),
+	interval:   2 * time.Second,
+	batchSize:  200,
+	staleAfter: 30 * time.Second,
+}
+
+func initOutbox(ctx context.Context) {
+	outbox.interval = time.Duration(g.Cfg().MustGet(ctx, "outbox.pollInterval", 2000).Int()) * time.Millisecond
+	outbox.batchSize = g.Cfg().MustGet(ctx, "outbox.batchSize", outbox.batchSize).Int()
+	outbox.staleAfter = time.Duration(g.Cfg().MustGet(ctx, "outbox.staleAfter", 30).Int()) * time.Second
+	go outbox.run(context.Background())
+}
+
+// kick 事务提交后调用，唤醒 dispatcher 立即投递
+func (d *outboxDispatcher) kick() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *outboxDispatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+		// 一次唤醒把积压投完
+		for d.dispatch(ctx) == d.batchSize {
+		}
+	}
+}
+
+// dispatch 认领并投递一批，返回本批条数
+func (d *outboxDispatcher) dispatch(ctx context.Context) int {
+	token := fmt.Sprintf("%s-%d", cluster.nodeID, time.Now().UnixNano())
+	entries, err := store.Outbox.Claim(ctx, token, d.batchSize, d.staleAfter)
+	if err != nil {
+		g.Log().Warningf(ctx, "outbox claim failed: %v", err)
+		return 0
+	}
+	if len(entries) == 0 {
+		return 0
+	}
+
+	ids := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		payload := json.RawMessage(e.Payload)
+		if e.ObjectKey != "" {
+			payload = signPushFrame(payload, e.ObjectKey)
+		}
+		cluster.Push(e.UserID, coalesced(e.Key, payload))
+		ids = append(ids, e.ID)
+	}
+	if err := store.Outbox.Done(ctx, ids); err != nil {
+		// 删除失败的记录在 staleAfter 后会被重新投递
+		g.Log().Warningf(ctx, "outbox done failed: %v", err)
+	}
+	return len(entries)
+}
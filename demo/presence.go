@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// ---------------------- 在线状态（presence） ----------------------
+// 状态：online（有连接且未全部离开）/ away（连接都设为离开）/ offline（无连接）。
+// 状态与最后在线时间写入 users.presence / users.last_seen_at，仅在状态变化时更新。
+// 用户列表、会话列表从这里读取；user_presence 只推给订阅了该用户的连接：
+//   - presence.subscribe / presence.unsubscribe 按连接订阅，断开即失效，重连后需重新订阅
+//   - 集群模式下状态变化经推送频道分发，各节点投递给本机的订阅者
+// 集群模式下每个连接的状态随在线登记写入 Redis，状态取所有节点的最佳值（online > away > offline），
+// 由 Redis 原子比较上次发布的状态决定是否写库/推送，避免多节点各自按本机连接互相覆盖。
+// 节点宕机未来得及写 offline 时，以集群在线登记为准（过期即视为 offline）。
+
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// PresenceInfo 对外返回的在线状态
+type PresenceInfo struct {
+	UserID     int        `json:"user_id"`
+	Status     string     `json:"status"`
+	Online     bool       `json:"online"` // online / away 均为 true，兼容只认布尔值的客户端
+	LastSeenAt *time.Time `json:"last_seen_at"`
+}
+
+func (p PresenceInfo) isOnline() int {
+	if p.Status == PresenceOffline {
+		return 2
+	}
+	return 1
+}
+
+type presenceService struct {
+	mu      sync.Mutex
+	away    map[*Client]bool
+	subs    map[int]map[*Client]bool // 被订阅的 uid -> 订阅连接
+	subsOf  map[*Client]map[int]bool // 连接 -> 订阅的 uid
+	current map[int]string           // 单节点模式下最近写入的状态，避免重复写库/推送
+
+	maxSubscriptions int // 单连接最多订阅人数
+}
+
+var presence = &presenceService{
+	away:             make(map[*Client]bool),
+	subs:             make(map[int]map[*Client]bool),
+	subsOf:           make(map[*Client]map[int]bool),
+	current:          make(map[int]string),
+	maxSubscriptions: 500,
+}
+
+func initPresence(ctx context.Context) {
+	presence.maxSubscriptions = g.Cfg().MustGet(ctx, "presence.maxSubscriptions", presence.maxSubscriptions).Int()
+}
+
+// resolvePresence 以集群在线登记校正库中的状态，online 由调用方批量查询（cluster.OnlineSet）
+func resolvePresence(u *TalkUser, online bool) PresenceInfo {
+	info := PresenceInfo{UserID: u.UserID, Status: u.Presence, Online: online, LastSeenAt: u.LastSeenAt}
+	switch {
+	case !online:
+		info.Status = PresenceOffline
+	case info.Status != PresenceAway:
+		info.Status = PresenceOnline
+	}
+	return info
+}
+
+// Lookup 批量查询在线状态，未登记的用户视为 offline
+func (p *presenceService) Lookup(ctx context.Context, uids []int) map[int]PresenceInfo {
+	result := make(map[int]PresenceInfo, len(uids))
+	users, err := store.Users.GetByUserIDs(ctx, uids)
+	if err != nil {
+		g.Log().Warningf(ctx, "presence lookup failed: %v", err)
+	}
+	online := cluster.OnlineSet(uids)
+	for i := range users {
+		result[users[i].UserID] = resolvePresence(&users[i], online[users[i].UserID])
+	}
+	for _, uid := range uids {
+		if _, ok := result[uid]; !ok {
+			result[uid] = resolvePresence(&TalkUser{UserID: uid, Presence: PresenceOffline}, online[uid])
+		}
+	}
+	return result
+}
+
+// fillSessionPresence 为 uid 的单聊会话填充对方在线状态
+func fillSessionPresence(ctx context.Context, uid int, list []TalkSession) {
+	var peers []int
+	for _, sess := range list {
+		if sess.Type != SessionTypeGroup {
+			peers = append(peers, sessionPeer(&sess, uid))
+		}
+	}
+	if len(peers) == 0 {
+		return
+	}
+	states := presence.Lookup(ctx, peers)
+	for i := range list {
+		if list[i].Type == SessionTypeGroup {
+			continue
+		}
+		p := states[sessionPeer(&list[i], uid)]
+		list[i].IsOnline = p.isOnline()
+		list[i].Presence = p.Status
+		list[i].LastSeenAt = p.LastSeenAt
+	}
+}
+
+// sessionPeer 单聊会话中 uid 的对方
+func sessionPeer(sess *TalkSession, uid int) int {
+	if sess.SendID == uid {
+		return sess.ReceiverID
+	}
+	return sess.SendID
+}
+
+// localStatus 按本节点连接计算状态
+func (p *presenceService) localStatus(uid int) string {
+	clients := hub.Clients(uid)
+	if len(clients) == 0 {
+		return PresenceOffline
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range clients {
+		if !p.away[c] {
+			return PresenceOnline
+		}
+	}
+	return PresenceAway
+}
+
+// connStatus 单个连接的状态，随集群在线登记写入 Redis
+func (p *presenceService) connStatus(c *Client) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.away[c] {
+		return PresenceAway
+	}
+	return PresenceOnline
+}
+
+// refresh 重新计算 uid 的状态，有变化时写库并通知订阅者
+func (p *presenceService) refresh(ctx context.Context, uid int) {
+	status, changed := p.swap(ctx, uid)
+	if !changed {
+		return
+	}
+
+	now := time.Now()
+	if err := store.Users.SetPresence(ctx, uid, status, now); err != nil {
+		g.Log().Warningf(ctx, "presence save failed: %v", err)
+	}
+	cluster.PushPresence(uid, coalesced(coalesceKey("user_presence", uid), map[string]any{
+		"event": "user_presence",
+		"data": PresenceInfo{
+			UserID:     uid,
+			Status:     status,
+			Online:     status != PresenceOffline,
+			LastSeenAt: &now,
+		},
+	}))
+}
+
+// swap 计算 uid 的当前状态并与上次发布的比较：
+// 集群模式取所有节点的聚合状态，在 Redis 中比较；单节点按本机连接计算，在 current 中比较
+func (p *presenceService) swap(ctx context.Context, uid int) (string, bool) {
+	if cluster.enabled {
+		status, changed, err := cluster.SwapStatus(ctx, uid)
+		if err == nil {
+			return status, changed
+		}
+		g.Log().Warningf(ctx, "presence aggregate failed: %v", err)
+		return "", false
+	}
+
+	status := p.localStatus(uid)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current[uid] == status {
+		return status, false
+	}
+	if status == PresenceOffline {
+		delete(p.current, uid)
+	} else {
+		p.current[uid] = status
+	}
+	return status, true
+}
+
+// Connected 连接登记后调用
+func (p *presenceService) Connected(ctx context.Context, c *Client) {
+	p.refresh(ctx, c.UserID)
+}
+
+// Disconnected 连接注销后调用，清理该连接的订阅
+func (p *presenceService) Disconnected(ctx context.Context, c *Client) {
+	p.mu.Lock()
+	delete(p.away, c)
+	for uid := range p.subsOf[c] {
+		if set := p.subs[uid]; set != nil {
+			delete(set, c)
+			if len(set) == 0 {
+				delete(p.subs, uid)
+			}
+		}
+	}
+	delete(p.subsOf, c)
+	p.mu.Unlock()
+	p.refresh(ctx, c.UserID)
+}
+
+// SetAway 设置连接的离开状态
+func (p *presenceService) SetAway(ctx context.Context, c *Client, away bool) {
+	p.mu.Lock()
+	if away {
+		p.away[c] = true
+	} else {
+		delete(p.away, c)
+	}
+	p.mu.Unlock()
+	if cluster.enabled {
+		// 立即更新本连接的登记，其他节点据此聚合
+		cluster.touch(ctx, c)
+	}
+	p.refresh(ctx, c.UserID)
+}
+
+// Subscribe 订阅 uids 的状态变化，超出上限返回 false
+func (p *presenceService) Subscribe(c *Client, uids []int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	mine := p.subsOf[c]
+	if mine == nil {
+		mine = make(map[int]bool)
+	}
+	added := make(map[int]bool)
+	for _, uid := range uids {
+		if uid != 0 && !mine[uid] {
+			added[uid] = true
+		}
+	}
+	if len(mine)+len(added) > p.maxSubscriptions {
+		return false
+	}
+	p.subsOf[c] = mine
+	for uid := range added {
+		mine[uid] = true
+		if p.subs[uid] == nil {
+			p.subs[uid] = make(map[*Client]bool)
+		}
+		p.subs[uid][c] = true
+	}
+	return true
+}
+
+// Unsubscribe 取消订阅
+func (p *presenceService) Unsubscribe(c *Client, uids []int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, uid := range uids {
+		delete(p.subsOf[c], uid)
+		if set := p.subs[uid]; set != nil {
+			delete(set, c)
+			if len(set) == 0 {
+				delete(p.subs, uid)
+			}
+		}
+	}
+}
+
+// deliverLocal 推给本节点订阅了 uid 的连接
+func (p *presenceService) deliverLocal(uid int, payload any) {
+	p.mu.Lock()
+	list := make([]*Client, 0, len(p.subs[uid]))
+	for c := range p.subs[uid] {
+		list = append(list, c)
+	}
+	p.mu.Unlock()
+	for _, c := range list {
+		sendWS(c, payload)
+	}
+}
+
+// ---------------------- WS 事件 ----------------------
+
+// presence.set 设置自己的状态
+// data: { "status":"away" }  // online | away
+func wsPresenceSet(ctx context.Context, c *Client, in *wsFrame) {
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(in.Data, &req); err != nil || (req.Status != PresenceOnline && req.Status != PresenceAway) {
+		sendAckError(c, in, newBizError(400, "参数错误"))
+		return
+	}
+	presence.SetAway(ctx, c, req.Status == PresenceAway)
+	sendAck(c, in, 0, "ok", map[string]any{"status": presence.localStatus(c.UserID)})
+}
+
+// presence.subscribe 订阅联系人状态，ack 返回当前状态
+// data: { "user_ids":[2,3] }
+func wsPresenceSubscribe(ctx context.Context, c *Client, in *wsFrame) {
+	var req struct {
+		UserIDs []int `json:"user_ids"`
+	}
+	if err := json.Unmarshal(in.Data, &req); err != nil || len(req.UserIDs) == 0 {
+		sendAckError(c, in, newBizError(400, "参数错误"))
+		return
+	}
+	if !presence.Subscribe(c, req.UserIDs) {
+		sendAckError(c, in, newBizError(400, "订阅人数超过上限"))
+		return
+	}
+	states := presence.Lookup(ctx, req.UserIDs)
+	list := make([]PresenceInfo, 0, len(req.UserIDs))
+	for _, uid := range req.UserIDs {
+		list = append(list, states[uid])
+	}
+	sendAck(c, in, 0, "success", map[string]any{"list": list})
+}
+
+// presence.unsubscribe 取消订阅
+// data: { "user_ids":[2,3] }
+func wsPresenceUnsubscribe(ctx context.Context, c *Client, in *wsFrame) {
+	var req struct {
+		UserIDs []int `json:"user_ids"`
+	}
+	if err := json.Unmarshal(in.Data, &req); err != nil {
+		sendAckError(c, in, newBizError(400, "参数错误"))
+		return
+	}
+	presence.Unsubscribe(c, req.UserIDs)
+	sendAck(c, in, 0, "ok", map[string]any{"user_ids": req.UserIDs})
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// ---------------------- 复核报价卡片 ----------------------
+// POST /talk/review 由报价方发起，生成一条 msg_type=1001 的报价卡片（quote 表为准，消息 payload 为快照）。
+// 接收方可对待处理（pending）的报价执行：
+//   - accept  接受
+//   - reject  拒绝
+//   - counter 还价：给出新的明细/备注，报价进入 countered，报价方可据此重新发起复核
+// 每次状态变化都写入 quote_action 审计记录，更新卡片 payload，并向双方推送 im.quote。
+// 过期的报价在下一次操作时置为 expired（审计记录 actor_id=0）。
+// 报价方撤回卡片时，待处理的报价在同一事务中置为 expired（审计 action=recall），撤回后的卡片不能再操作。
+// 金额单位为分，合计由服务端计算。
+
+const MsgTypeQuote = 1001
+
+const (
+	QuoteStatePending   = "pending"
+	QuoteStateAccepted  = "accepted"
+	QuoteStateRejected  = "rejected"
+	QuoteStateCountered = "countered"
+	QuoteStateExpired   = "expired"
+)
+
+const (
+	quoteMaxItems     = 50
+	quoteMaxQuantity  = 1000000
+	quoteMaxUnitPrice = 10000000000 // 1 亿元（分）
+)
+
+// quoteActions 操作 -> 目标状态
+var quoteActions = map[string]string{
+	"accept":  QuoteStateAccepted,
+	"reject":  QuoteStateRejected,
+	"counter": QuoteStateCountered,
+}
+
+var quoteValidHours = 72
+
+func initQuote(ctx context.Context) {
+	quoteValidHours = g.Cfg().MustGet(ctx, "quote.validHours", quoteValidHours).Int()
+}
+
+type Quote struct {
+	ID         int             `gorm:"primaryKey;column:id" json:"id"`
+	MessageID  int             `gorm:"column:message_id;index" json:"message_id"`
+	SendID     int             `gorm:"column:send_id" json:"send_id"`         // 报价方
+	ReceiverID int             `gorm:"column:receiver_id" json:"receiver_id"` // 接收方（可操作）
+	Title      string          `gorm:"column:title;size:64" json:"title"`
+	Items      json.RawMessage `gorm:"column:items;type:text" json:"items"` // []QuoteItem
+	Currency   string          `gorm:"column:currency;size:8" json:"currency"`
+	Total      int64           `gorm:"column:total" json:"total"` // 合计（分）
+	Note       string          `gorm:"column:note;size:500" json:"note"`
+	State      string          `gorm:"column:state;size:16" json:"state"`
+	Counter    json.RawMessage `gorm:"column:counter;type:text" json:"counter,omitempty"` // 还价内容 QuoteCounter
+	ExpiresAt  time.Time       `gorm:"column:expires_at" json:"expires_at"`
+	CreatedAt  time.Time       `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time       `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+}
+
+func (Quote) TableName() string { return "quote" }
+
+// QuoteAction 审计记录
+type QuoteAction struct {
+	ID        int             `gorm:"primaryKey;column:id" json:"id"`
+	QuoteID   int             `gorm:"column:quote_id;index" json:"quote_id"`
+	ActorID   int             `gorm:"column:actor_id" json:"actor_id"` // 0 表示系统（过期）
+	Action    string          `gorm:"column:action;size:16" json:"action"`
+	FromState string          `gorm:"column:from_state;size:16" json:"from_state"`
+	ToState   string          `gorm:"column:to_state;size:16" json:"to_state"`
+	Detail    json.RawMessage `gorm:"column:detail;type:text" json:"detail,omitempty"`
+	CreatedAt time.Time       `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (QuoteAction) TableName() string { return "quote_action" }
+
+type QuoteItem struct {
+	Name      string `json:"name"`
+	Quantity  int64  `json:"quantity"`
+	UnitPrice int64  `json:"unit_price"` // 单价（分）
+	Amount    int64  `json:"amount"`     // 小计（分），服务端计算
+}
+
+type QuoteCounter struct {
+	Items []QuoteItem `json:"items"`
+	Total int64       `json:"total"`
+	Note  string      `json:"note"`
+}
+
+// normalizeQuoteItems 校验明细并计算小计与合计
+func normalizeQuoteItems(items []QuoteItem) ([]QuoteItem, int64, *bizError) {
+	if len(items) > quoteMaxItems {
+		return nil, 0, newBizError(400, "报价明细过多")
+	}
+	var total int64
+	list := make([]QuoteItem, 0, len(items))
+	for _, it := range items {
+		it.Name = strings.TrimSpace(it.Name)
+		if it.Name == "" || utf8.RuneCountInString(it.Name) > 64 {
+			return nil, 0, newBizError(400, "明细名称不能为空且不超过64字")
+		}
+		if it.Quantity <= 0 || it.Quantity > quoteMaxQuantity || it.UnitPrice < 0 || it.UnitPrice > quoteMaxUnitPrice {
+			return nil, 0, newBizError(400, "数量或单价不合法")
+		}
+		it.Amount = it.Quantity * it.UnitPrice
+		total += it.Amount
+		list = append(list, it)
+	}
+	return list, total, nil
+}
+
+// QuoteReq 发起复核报价时的报价内容
+type QuoteReq struct {
+	Title      string      `json:"title"`       // 默认 "复核报价"
+	Items      []QuoteItem `json:"items"`       // 明细，可为空
+	Currency   string      `json:"currency"`    // 默认 CNY
+	Note       string      `json:"note"`        // 备注
+	ValidHours int         `json:"valid_hours"` // 有效期（小时），默认 quote.validHours
+}
+
+// newQuote 校验报价内容，生成待落库的 Quote
+func newQuote(sendID, receiverID int, req *QuoteReq) (*Quote, *bizError) {
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		title = "复核报价"
+	}
+	currency := strings.ToUpper(strings.TrimSpace(req.Currency))
+	if currency == "" {
+		currency = "CNY"
+	}
+	if utf8.RuneCountInString(title) > 64 || len(currency) > 8 || utf8.RuneCountInString(req.Note) > 500 {
+		return nil, newBizError(400, "参数错误")
+	}
+	validHours := req.ValidHours
+	if validHours <= 0 {
+		validHours = quoteValidHours
+	}
+	if validHours > 24*30 {
+		return nil, newBizError(400, "有效期不能超过30天")
+	}
+	items, total, bizErr := normalizeQuoteItems(req.Items)
+	if bizErr != nil {
+		return nil, bizErr
+	}
+	data, _ := json.Marshal(items)
+	return &Quote{
+		SendID:     sendID,
+		ReceiverID: receiverID,
+		Title:      title,
+		Items:      data,
+		Currency:   currency,
+		Total:      total,
+		Note:       req.Note,
+		State:      QuoteStatePending,
+		ExpiresAt:  time.Now().Add(time.Duration(validHours) * time.Hour),
+	}, nil
+}
+
+// payload 卡片快照（msg_type=1001 的 payload）
+func (q *Quote) payload() json.RawMessage {
+	p := map[string]any{
+		"quote_id":   q.ID,
+		"title":      q.Title,
+		"items":      q.Items,
+		"currency":   q.Currency,
+		"total":      q.Total,
+		"note":       q.Note,
+		"state":      q.State,
+		"expires_at": q.ExpiresAt.Format("2006-01-02 15:04:05"),
+	}
+	if len(q.Counter) > 0 {
+		p["counter"] = q.Counter
+	}
+	data, _ := json.Marshal(p)
+	return data
+}
+
+func (q *Quote) preview() string {
+	return "[" + q.Title + "]"
+}
+
+var errQuoteConflict = errors.New("quote state changed")
+
+// actOnQuote 接收方处理报价
+func actOnQuote(ctx context.Context, uid, quoteID int, action string, counter *QuoteCounter) (*Quote, *bizError) {
+	toState, ok := quoteActions[action]
+	if uid == 0 || quoteID == 0 || !ok {
+		return nil, newBizError(400, "参数错误")
+	}
+	q, err := store.Quotes.Get(ctx, quoteID)
+	if err != nil || (q.SendID != uid && q.ReceiverID != uid) {
+		return nil, newBizError(404, "报价不存在")
+	}
+	if q.ReceiverID != uid {
+		return nil, newBizError(403, "只有接收方可以处理报价")
+	}
+	if q.State != QuoteStatePending {
+		return nil, newBizError(400, "报价已处理")
+	}
+	if msg, err := store.Messages.Get(ctx, q.MessageID); err != nil || msg.IsRecalled == 1 {
+		return nil, newBizError(400, "报价卡片已撤回")
+	}
+
+	var detail json.RawMessage
+	var counterData json.RawMessage
+	if time.Now().After(q.ExpiresAt) {
+		action, toState = "expire", QuoteStateExpired
+		uid = 0
+	} else if action == "counter" {
+		if counter == nil {
+			return nil, newBizError(400, "参数错误")
+		}
+		items, total, bizErr := normalizeQuoteItems(counter.Items)
+		if bizErr != nil {
+			return nil, bizErr
+		}
+		if len(items) == 0 || utf8.RuneCountInString(counter.Note) > 500 {
+			return nil, newBizError(400, "还价需要给出明细")
+		}
+		counterData, _ = json.Marshal(QuoteCounter{Items: items, Total: total, Note: counter.Note})
+		detail = counterData
+	}
+
+	var fresh *Quote
+	err = store.Tx(ctx, func(st *Stores) error {
+		changed, err := st.Quotes.Transition(ctx, q.ID, QuoteStatePending, toState, counterData)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return errQuoteConflict
+		}
+		if err := st.Quotes.AddAction(ctx, &QuoteAction{
+			QuoteID: q.ID, ActorID: uid, Action: action,
+			FromState: QuoteStatePending, ToState: toState, Detail: detail,
+		}); err != nil {
+			return err
+		}
+		if fresh, err = st.Quotes.Get(ctx, q.ID); err != nil {
+			return err
+		}
+		payload := fresh.payload()
+		if err := st.Messages.SetPayload(ctx, fresh.MessageID, fresh.preview(), payload); err != nil {
+			return err
+		}
+
+		var batch outboxBatch
+		frame := map[string]any{
+			"event": "im.quote",
+			"data": map[string]any{
+				"quote_id":   fresh.ID,
+				"message_id": fresh.MessageID,
+				"action":     action,
+				"actor_id":   uid,
+				"state":      fresh.State,
+				"payload":    payload,
+			},
+		}
+		batch.push(fresh.SendID, frame)
+		batch.push(fresh.ReceiverID, frame)
+		return batch.save(ctx, st)
+	})
+	if errors.Is(err, errQuoteConflict) {
+		return nil, newBizError(400, "报价已处理")
+	}
+	if err != nil {
+		g.Log().Warningf(ctx, "quote action failed, id=%d: %v", quoteID, err)
+		return nil, newBizError(500, "操作失败")
+	}
+	outbox.kick()
+	if toState == QuoteStateExpired {
+		return nil, newBizError(400, "报价已过期")
+	}
+	return fresh, nil
+}
+
+// expireRecalledQuote 撤回报价卡片时在同一事务中让待处理的报价失效，已处理的保持不变
+func expireRecalledQuote(ctx context.Context, st *Stores, msg *TalkMessage, uid int) error {
+	var p struct {
+		QuoteID int `json:"quote_id"`
+	}
+	if msg.MsgType != MsgTypeQuote || json.Unmarshal(msg.Payload, &p) != nil || p.QuoteID == 0 {
+		return nil
+	}
+	changed, err := st.Quotes.Transition(ctx, p.QuoteID, QuoteStatePending, QuoteStateExpired, nil)
+	if err != nil || !changed {
+		return err
+	}
+	return st.Quotes.AddAction(ctx, &QuoteAction{
+		QuoteID: p.QuoteID, ActorID: uid, Action: "recall",
+		FromState: QuoteStatePending, ToState: QuoteStateExpired,
+	})
+}
+
+func init() {
+	registerMessageType(&MessageType{
+		Code: MsgTypeQuote,
+		Name: "quote",
+		Fields: []payloadField{
+			{Name: "quote_id", Kind: fieldInteger, Required: true},
+			{Name: "title", Kind: fieldString, Required: true, MaxLen: 64},
+			{Name: "items", Kind: fieldArray, Desc: "明细 [{name, quantity, unit_price, amount}]，金额单位分"},
+			{Name: "currency", Kind: fieldString, MaxLen: 8},
+			{Name: "total", Kind: fieldInteger, Desc: "合计（分）"},
+			{Name: "note", Kind: fieldString, MaxLen: 500},
+			{Name: "state", Kind: fieldString, Required: true, Desc: "pending / accepted / rejected / countered / expired"},
+			{Name: "expires_at", Kind: fieldString},
+			{Name: "counter", Kind: fieldObject, Desc: "还价 {items, total, note}"},
+		},
+		Render:  RenderMeta{Component: "quote_card", Icon: "quote"},
+		System:  true,
+		preview: func(p map[string]any) string { return "[" + payloadString(p, "title") + "]" },
+	})
+}
+
+// ---------------------- HTTP Handlers ----------------------
+
+// 处理报价（接收方）
+// POST /talk/quote/action
+// body: { "quote_id":1, "user_id":2, "action":"accept|reject|counter", "counter":{"items":[{"name":"A","quantity":2,"unit_price":900}],"note":"..."} }
+func quoteActionHandler(r *ghttp.Request) {
+	var req struct {
+		QuoteID int           `json:"quote_id"`
+		UserID  int           `json:"user_id"`
+		Action  string        `json:"action"`
+		Counter *QuoteCounter `json:"counter"`
+	}
+	err := r.Parse(&req)
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	q, bizErr := actOnQuote(r.Context(), req.UserID, req.QuoteID, req.Action, req.Counter)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": q})
+}
+
+// 报价详情与操作记录
+// POST /talk/quote/detail
+// body: { "quote_id":1, "user_id":2 }
+func quoteDetailHandler(r *ghttp.Request) {
+	var req struct {
+		QuoteID int `json:"quote_id"`
+		UserID  int `json:"user_id"`
+	}
+	err := r.Parse(&req)
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if err != nil || req.QuoteID == 0 || req.UserID == 0 {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	ctx := r.Context()
+	q, err := store.Quotes.Get(ctx, req.QuoteID)
+	if err != nil || (q.SendID != req.UserID && q.ReceiverID != req.UserID) {
+		r.Response.WriteJsonExit(g.Map{"code": 404, "msg": "报价不存在"})
+		return
+	}
+	actions, err := store.Quotes.Actions(ctx, q.ID)
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 500, "msg": "查询失败"})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": g.Map{
+		"quote":   q,
+		"actions": actions,
+	}})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// ---------------------- 表情回应 ----------------------
+// 每人对同一条消息的同一个表情只记一次（message_reaction 唯一键 message_id + user_id + emoji），
+// 可以对同一条消息回应多个不同表情。
+// 增删后会话参与者收到 im.reaction；消息列表、消息串、增量同步返回按表情聚合的计数。
+
+const reactionEmojiMaxLen = 32 // emoji 最大字节数（含肤色/ZWJ 组合）
+
+type MessageReaction struct {
+	ID        int       `gorm:"primaryKey;column:id" json:"id"`
+	MessageID int       `gorm:"column:message_id;uniqueIndex:uk_message_reaction,priority:1" json:"message_id"`
+	UserID    int       `gorm:"column:user_id;uniqueIndex:uk_message_reaction,priority:2" json:"user_id"`
+	Emoji     string    `gorm:"column:emoji;size:32;uniqueIndex:uk_message_reaction,priority:3" json:"emoji"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (MessageReaction) TableName() string { return "message_reaction" }
+
+// ReactionCount 按表情聚合的回应数
+type ReactionCount struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"` // 当前用户是否回应过
+}
+
+func validEmoji(emoji string) bool {
+	return emoji != "" && len(emoji) <= reactionEmojiMaxLen && utf8.ValidString(emoji)
+}
+
+// reactToMessage add=true 添加回应，false 取消
+func reactToMessage(ctx context.Context, uid, id int, emoji string, add bool) ([]ReactionCount, *bizError) {
+	if uid == 0 || id == 0 || !validEmoji(emoji) {
+		return nil, newBizError(400, "参数错误")
+	}
+	msg, err := store.Messages.Get(ctx, id)
+	if err != nil || !canViewMessage(ctx, uid, msg) {
+		return nil, newBizError(404, "消息不存在")
+	}
+	if add && msg.IsRecalled == 1 {
+		return nil, newBizError(400, "消息已撤回")
+	}
+
+	var counts []ReactionCount
+	err = store.Tx(ctx, func(st *Stores) error {
+		var changed bool
+		var err error
+		if add {
+			changed, err = st.Messages.AddReaction(ctx, msg.ID, uid, emoji)
+		} else {
+			changed, err = st.Messages.RemoveReaction(ctx, msg.ID, uid, emoji)
+		}
+		if err != nil {
+			return err
+		}
+		all, err := st.Messages.Reactions(ctx, uid, []int{msg.ID})
+		if err != nil {
+			return err
+		}
+		counts = all[msg.ID]
+		if !changed {
+			return nil
+		}
+
+		count := 0
+		for _, c := range counts {
+			if c.Emoji == emoji {
+				count = c.Count
+			}
+		}
+		action := "add"
+		if !add {
+			action = "remove"
+		}
+		uids, err := messageParticipants(ctx, st, msg)
+		if err != nil {
+			return err
+		}
+		var batch outboxBatch
+		for _, u := range uids {
+			batch.push(u, map[string]any{
+				"event": "im.reaction",
+				"sid":   msg.Sid,
+				"data": map[string]any{
+					"message_id": msg.ID,
+					"sid":        msg.Sid,
+					"user_id":    uid,
+					"emoji":      emoji,
+					"action":     action,
+					"count":      count, // 该表情变更后的总数
+				},
+			})
+		}
+		return batch.save(ctx, st)
+	})
+	if err != nil {
+		return nil, newBizError(500, "操作失败")
+	}
+	outbox.kick()
+	return counts, nil
+}
+
+// fillReactions 为消息列表批量填充回应计数
+func fillReactions(ctx context.Context, uid int, msgs []TalkMessage) {
+	if len(msgs) == 0 {
+		return
+	}
+	ids := make([]int, 0, len(msgs))
+	for _, m := range msgs {
+		ids = append(ids, m.ID)
+	}
+	all, err := store.Messages.Reactions(ctx, uid, ids)
+	if err != nil {
+		return
+	}
+	for i := range msgs {
+		msgs[i].Reactions = all[msgs[i].ID]
+	}
+}
+
+// ---------------------- HTTP Handlers ----------------------
+
+type reactionReq struct {
+	MessageID int    `json:"message_id"`
+	UserID    int    `json:"user_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// 添加表情回应
+// POST /talk/message/reaction/add
+// body: { "message_id":5001, "user_id":1, "emoji":"👍" }
+func addReactionHandler(r *ghttp.Request) {
+	reactionHandler(r, true)
+}
+
+// 取消表情回应
+// POST /talk/message/reaction/remove
+// body: { "message_id":5001, "user_id":1, "emoji":"👍" }
+func removeReactionHandler(r *ghttp.Request) {
+	reactionHandler(r, false)
+}
+
+func reactionHandler(r *ghttp.Request, add bool) {
+	var req reactionReq
+	err := r.Parse(&req)
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	counts, bizErr := reactToMessage(r.Context(), req.UserID, req.MessageID, req.Emoji, add)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": g.Map{
+		"message_id": req.MessageID,
+		"reactions":  counts,
+	}})
+}
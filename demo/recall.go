@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// ---------------------- 撤回 / 编辑 / 仅自己删除 ----------------------
+//   - 撤回：发送者在 message.recallWindow 秒内可撤回，内容清空，会话参与者收到 im.recall
+//   - 编辑：发送者可修改可编辑类型（文本）的消息，旧内容写入 message_edit，会话参与者收到 im.edit
+//   - 删除：只对自己隐藏（message_hidden），消息列表与增量同步中不再返回，自己的其他设备收到 im.delete
+// 撤回/编辑的是会话最后一条消息时同步更新会话 msg_text；删除只影响自己，不改共享的会话。
+
+const MsgTypeText = 1
+
+const recalledText = "[消息已撤回]"
+
+var recallWindow = 2 * time.Minute
+
+func initMessageOps(ctx context.Context) {
+	recallWindow = time.Duration(g.Cfg().MustGet(ctx, "message.recallWindow", 120).Int()) * time.Second
+}
+
+// 编辑历史
+type MessageEdit struct {
+	ID        int       `gorm:"primaryKey;column:id" json:"id"`
+	MessageID int       `gorm:"column:message_id;index" json:"message_id"`
+	EditorID  int       `gorm:"column:editor_id" json:"editor_id"`
+	Content   string    `gorm:"column:content" json:"content"` // 编辑前的内容
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (MessageEdit) TableName() string { return "message_edit" }
+
+// 仅自己删除
+type MessageHidden struct {
+	ID        int       `gorm:"primaryKey;column:id" json:"id"`
+	MessageID int       `gorm:"column:message_id;uniqueIndex:uk_message_hidden_user_msg,priority:2" json:"message_id"`
+	UserID    int       `gorm:"column:user_id;uniqueIndex:uk_message_hidden_user_msg,priority:1" json:"user_id"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (MessageHidden) TableName() string { return "message_hidden" }
+
+// loadOwnMessage 加载 uid 自己发送的消息
+func loadOwnMessage(ctx context.Context, uid, id int) (*TalkMessage, *bizError) {
+	if uid == 0 || id == 0 {
+		return nil, newBizError(400, "参数错误")
+	}
+	msg, err := store.Messages.Get(ctx, id)
+	if err != nil {
+		return nil, newBizError(404, "消息不存在")
+	}
+	if msg.SendID != uid {
+		return nil, newBizError(403, "只能操作自己发送的消息")
+	}
+	return msg, nil
+}
+
+// isLastMessage 消息是否为会话最后一条；未回填 sid 的历史消息不属于任何会话，不更新会话
+func isLastMessage(ctx context.Context, st *Stores, msg *TalkMessage) bool {
+	if msg.Sid == 0 {
+		return false
+	}
+	last, err := st.Messages.List(ctx, MessageQuery{Sid: msg.Sid, Limit: 1})
+	return err == nil && len(last) == 1 && last[0].ID == msg.ID
+}
+
+// recallMessage 撤回消息
+func recallMessage(ctx context.Context, uid, id int) (*TalkMessage, *bizError) {
+	msg, bizErr := loadOwnMessage(ctx, uid, id)
+	if bizErr != nil {
+		return nil, bizErr
+	}
+	if msg.IsRecalled == 1 {
+		return msg, nil
+	}
+	if time.Since(msg.CreatedAt) > recallWindow {
+		return nil, newBizError(400, "已超过可撤回时间")
+	}
+
+	err := store.Tx(ctx, func(st *Stores) error {
+		if err := st.Messages.Recall(ctx, msg.ID); err != nil {
+			return err
+		}
+		if err := expireRecalledQuote(ctx, st, msg, uid); err != nil {
+			return err
+		}
+		var batch outboxBatch
+		if isLastMessage(ctx, st, msg) {
+			if err := st.Sessions.SetLastText(ctx, msg.Sid, recalledText); err != nil {
+				return err
+			}
+			if err := batch.sessionUpdated(ctx, st, msg.Sid); err != nil {
+				return err
+			}
+		}
+		uids, err := messageParticipants(ctx, st, msg)
+		if err != nil {
+			return err
+		}
+		for _, u := range uids {
+			batch.push(u, map[string]any{
+				"event": "im.recall",
+				"sid":   msg.Sid,
+				"data":  map[string]any{"id": msg.ID, "sid": msg.Sid, "send_id": msg.SendID},
+			})
+		}
+		return batch.save(ctx, st)
+	})
+	if err != nil {
+		return nil, newBizError(500, "撤回失败")
+	}
+	outbox.kick()
+	unindexMessage(ctx, msg.ID)
+
+	msg.IsRecalled = 1
+	msg.Content = ""
+	msg.Payload = nil
+	return msg, nil
+}
+
+// editMessage 编辑文本消息
+func editMessage(ctx context.Context, uid, id int, content string) (*TalkMessage, *bizError) {
+	msg, bizErr := loadOwnMessage(ctx, uid, id)
+	if bizErr != nil {
+		return nil, bizErr
+	}
+	if msg.IsRecalled == 1 {
+		return nil, newBizError(400, "消息已撤回")
+	}
+	if t := messageTypeOf(msg.MsgType); t == nil || !t.Editable {
+		return nil, newBizError(400, "该类型消息不能编辑")
+	}
+	payload, preview, bizErr := buildPayload(msg.MsgType, content, nil)
+	if bizErr != nil {
+		return nil, bizErr
+	}
+	if msg.Content == preview {
+		return msg, nil
+	}
+
+	var fresh *TalkMessage
+	err := store.Tx(ctx, func(st *Stores) error {
+		if err := st.Messages.Edit(ctx, msg.ID, uid, preview, payload); err != nil {
+			return err
+		}
+		var err error
+		if fresh, err = st.Messages.Get(ctx, msg.ID); err != nil {
+			return err
+		}
+		var batch outboxBatch
+		if isLastMessage(ctx, st, msg) {
+			if err := st.Sessions.SetLastText(ctx, msg.Sid, preview); err != nil {
+				return err
+			}
+			if err := batch.sessionUpdated(ctx, st, msg.Sid); err != nil {
+				return err
+			}
+		}
+		uids, err := messageParticipants(ctx, st, msg)
+		if err != nil {
+			return err
+		}
+		for _, u := range uids {
+			batch.push(u, map[string]any{
+				"event": "im.edit",
+				"sid":   msg.Sid,
+				"data": map[string]any{
+					"id":        fresh.ID,
+					"sid":       fresh.Sid,
+					"send_id":   fresh.SendID,
+					"content":   fresh.Content,
+					"payload":   fresh.Payload,
+					"edited_at": fresh.EditedAt,
+				},
+			})
+		}
+		return batch.save(ctx, st)
+	})
+	if err != nil {
+		return nil, newBizError(500, "编辑失败")
+	}
+	outbox.kick()
+	indexMessage(ctx, fresh)
+	return fresh, nil
+}
+
+// deleteMessages 仅对自己删除，只处理 uid 可见的消息
+func deleteMessages(ctx context.Context, uid int, ids []int) ([]int, *bizError) {
+	if uid == 0 || len(ids) == 0 {
+		return nil, newBizError(400, "参数错误")
+	}
+	msgs, err := store.Messages.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, newBizError(500, "查询失败")
+	}
+	var visible []int
+	for _, m := range msgs {
+		if canViewMessage(ctx, uid, &m) {
+			visible = append(visible, m.ID)
+		}
+	}
+	if len(visible) == 0 {
+		return nil, newBizError(404, "消息不存在")
+	}
+	if err := store.Messages.Hide(ctx, uid, visible); err != nil {
+		return nil, newBizError(500, "删除失败")
+	}
+	// 自己的其他设备同步删除
+	cluster.Push(uid, map[string]any{"event": "im.delete", "data": map[string]any{"ids": visible}})
+	return visible, nil
+}
+
+// withoutHidden 过滤掉 uid 自己删除的消息
+func withoutHidden(ctx context.Context, uid int, msgs []TalkMessage) []TalkMessage {
+	if uid == 0 || len(msgs) == 0 {
+		return msgs
+	}
+	ids := make([]int, 0, len(msgs))
+	for _, m := range msgs {
+		ids = append(ids, m.ID)
+	}
+	hidden, err := store.Messages.HiddenIDs(ctx, uid, ids)
+	if err != nil || len(hidden) == 0 {
+		return msgs
+	}
+	list := make([]TalkMessage, 0, len(msgs))
+	for _, m := range msgs {
+		if !hidden[m.ID] {
+			list = append(list, m)
+		}
+	}
+	return list
+}
+
+// ---------------------- HTTP Handlers ----------------------
+
+// 撤回消息
+// POST /talk/message/recall
+// body: { "message_id":5001, "user_id":1 }
+func recallMessageHandler(r *ghttp.Request) {
+	var req struct {
+		MessageID int `json:"message_id"`
+		UserID    int `json:"user_id"`
+	}
+	err := r.Parse(&req)
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	msg, bizErr := recallMessage(r.Context(), req.UserID, req.MessageID)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": msg})
+}
+
+// 编辑消息（仅文本）
+// POST /talk/message/edit
+// body: { "message_id":5001, "user_id":1, "content":"修改后的内容" }
+func editMessageHandler(r *ghttp.Request) {
+	var req struct {
+		MessageID int    `json:"message_id"`
+		UserID    int    `json:"user_id"`
+		Content   string `json:"content"`
+	}
+	err := r.Parse(&req)
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	msg, bizErr := editMessage(r.Context(), req.UserID, req.MessageID, req.Content)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": msg})
+}
+
+// 编辑历史
+// POST /talk/message/edits
+// body: { "message_id":5001, "user_id":1 }
+func messageEditsHandler(r *ghttp.Request) {
+	var req struct {
+		MessageID int `json:"message_id"`
+		UserID    int `json:"user_id"`
+	}
+	err := r.Parse(&req)
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if err != nil || req.MessageID == 0 || req.UserID == 0 {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	ctx := r.Context()
+	msg, err := store.Messages.Get(ctx, req.MessageID)
+	if err != nil || !canViewMessage(ctx, req.UserID, msg) {
+		r.Response.WriteJsonExit(g.Map{"code": 404, "msg": "消息不存在"})
+		return
+	}
+	list, err := store.Messages.EditHistory(ctx, req.MessageID)
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 500, "msg": "查询失败"})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": list})
+}
+
+// 删除消息（仅自己不可见）
+// POST /talk/message/delete
+// body: { "ids":[5001,5002], "user_id":1 }
+func deleteMessagesHandler(r *ghttp.Request) {
+	var req struct {
+		IDs    []int `json:"ids"`
+		UserID int   `json:"user_id"`
+	}
+	err := r.Parse(&req)
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	ids, bizErr := deleteMessages(r.Context(), req.UserID, req.IDs)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": g.Map{"ids": ids}})
+}
@@ -0,0 +1,83 @@
+package main
+
+import "context"
+
+// ---------------------- 消息状态：已发送 -> 已送达 -> 已读 ----------------------
+// 状态只前进不后退：
+//   - 已发送：消息落库
+//   - 已送达：接收方设备收到推送后回 im.ack
+//   - 已读：接收方打开会话（im.read / POST /talk/message/read）
+// 每次状态前进都会向发送方推送 message_status，前端据此展示 ✓ / ✓✓ / 已读。
+
+const (
+	MsgStatusSent      = 1
+	MsgStatusDelivered = 2
+	MsgStatusRead      = 3
+)
+
+var msgStatusText = map[int]string{
+	MsgStatusSent:      "sent",
+	MsgStatusDelivered: "delivered",
+	MsgStatusRead:      "read",
+}
+
+// markDelivered 接收方确认送达，返回实际前进了状态的消息ID
+func markDelivered(ctx context.Context, userID int, ids []int) ([]int, *bizError) {
+	if userID == 0 || len(ids) == 0 {
+		return nil, newBizError(400, "参数错误")
+	}
+	return advanceStatus(ctx, MsgStatusDelivered, StatusFilter{IDs: ids, ReceiverID: userID}), nil
+}
+
+// markSessionRead 把会话中发给 userID 的消息置为已读并清零未读数
+func markSessionRead(ctx context.Context, sessionID, userID int) *bizError {
+	if sessionID == 0 || userID == 0 {
+		return newBizError(400, "参数错误")
+	}
+	// 群聊：只清零该成员自己的未读数
+	if m := activeMember(ctx, sessionID, userID); m != nil {
+		_ = store.Sessions.ClearMemberUnread(ctx, sessionID, userID)
+		return nil
+	}
+
+	advanceStatus(ctx, MsgStatusRead, StatusFilter{Sid: sessionID, ReceiverID: userID})
+
+	_ = store.Sessions.ClearUnread(ctx, sessionID, userID)
+	return nil
+}
+
+// advanceStatus 将满足条件且状态低于 status 的消息推进到 status，并通知发送方
+func advanceStatus(ctx context.Context, status int, f StatusFilter) []int {
+	msgs, err := store.Messages.AdvanceStatus(ctx, f, status)
+	if err != nil || len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]int, 0, len(msgs))
+	for _, m := range msgs {
+		ids = append(ids, m.ID)
+	}
+
+	notifyStatus(msgs, status)
+	return ids
+}
+
+// notifyStatus 按发送方分组推送 message_status
+func notifyStatus(msgs []TalkMessage, status int) {
+	bySender := make(map[int][]int)
+	receivers := make(map[int]int)
+	for _, m := range msgs {
+		bySender[m.SendID] = append(bySender[m.SendID], m.ID)
+		receivers[m.SendID] = m.ReceiverID
+	}
+	for sendID, ids := range bySender {
+		cluster.Push(sendID, map[string]any{
+			"event": "message_status",
+			"data": map[string]any{
+				"ids":         ids,
+				"status":      status,
+				"status_text": msgStatusText[status],
+				"receiver_id": receivers[sendID],
+			},
+		})
+	}
+}
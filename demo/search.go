@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"html"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// ---------------------- 消息全文搜索 ----------------------
+// 在调用者所在的全部会话中按内容搜索可搜索类型（文本）的消息，支持按会话、发送者、消息类型、日期范围过滤，
+// 结果按 id 倒序（新消息在前），以 before_id 游标翻页，附带高亮摘要。
+// 索引可插拔（search.driver）：
+//   - mysql：message.content 上的 FULLTEXT（ngram 分词），由 MySQL 维护，写入时无需额外操作
+//   - memory：进程内倒排索引，启动时从库中重建，SQLite 本地开发 / 测试使用
+// 撤回的消息从结果中排除，"仅自己删除"的消息对本人不可见。
+
+const (
+	searchKeywordMaxLen = 64 // 关键词最多字符数
+	searchSnippetLen    = 80 // 摘要最多字符数
+	searchSnippetLead   = 20 // 命中位置前保留的字符数
+	searchMaxScans      = 5  // 一页结果被过滤不满时最多扫描的轮数
+)
+
+// searchableMsgTypes 注册表中纳入搜索的消息类型
+func searchableMsgTypes() []int {
+	var list []int
+	for code, t := range messageTypes {
+		if t.Searchable {
+			list = append(list, code)
+		}
+	}
+	sort.Ints(list)
+	return list
+}
+
+// SearchQuery 索引查询条件
+type SearchQuery struct {
+	Terms    []string // 关键词（小写），全部命中才算匹配
+	Sids     []int    // 限定会话
+	SendID   int
+	MsgTypes []int
+	From     time.Time // 起始时间（含），零值不限
+	To       time.Time // 截止时间（不含），零值不限
+	BeforeID int
+	Limit    int
+}
+
+// SearchIndex 全文索引
+type SearchIndex interface {
+	// Index 写入/更新消息（非可搜索类型忽略）
+	Index(ctx context.Context, msg *TalkMessage) error
+	// Remove 从索引中移除（撤回）
+	Remove(ctx context.Context, id int) error
+	// Search 返回命中的消息 id（倒序），最多 q.Limit 条
+	Search(ctx context.Context, q SearchQuery) ([]int, error)
+}
+
+var searchIndex SearchIndex = newMemorySearchIndex()
+
+func initSearch(ctx context.Context) {
+	driver := g.Cfg().MustGet(ctx, "search.driver").String()
+	if driver == "" {
+		driver = "memory"
+		if db.Dialector.Name() == "mysql" {
+			driver = "mysql"
+		}
+	}
+	switch driver {
+	case "mysql":
+		searchIndex = &mysqlSearchIndex{db: db}
+	case "memory":
+		idx := newMemorySearchIndex()
+		if err := idx.rebuild(ctx, store.Messages); err != nil {
+			panic("failed to build search index: " + err.Error())
+		}
+		searchIndex = idx
+	default:
+		panic("unsupported search driver: " + driver)
+	}
+	g.Log().Infof(ctx, "search index: %s", driver)
+}
+
+// indexMessage 事务提交后更新索引，失败只记日志（不影响消息本身）
+func indexMessage(ctx context.Context, msg *TalkMessage) {
+	if err := searchIndex.Index(ctx, msg); err != nil {
+		g.Log().Warningf(ctx, "search index failed: id=%d err=%v", msg.ID, err)
+	}
+}
+
+func unindexMessage(ctx context.Context, id int) {
+	if err := searchIndex.Remove(ctx, id); err != nil {
+		g.Log().Warningf(ctx, "search unindex failed: id=%d err=%v", id, err)
+	}
+}
+
+func isSearchable(msgType int) bool {
+	t := messageTypeOf(msgType)
+	return t != nil && t.Searchable
+}
+
+// SearchReq 搜索请求
+type SearchReq struct {
+	UserID    int    `json:"user_id"`
+	Keyword   string `json:"keyword"`
+	SessionID int    `json:"session_id"` // 可选，限定会话
+	SendID    int    `json:"send_id"`    // 可选，限定发送者
+	MsgType   int    `json:"msg_type"`   // 可选，限定消息类型
+	StartDate string `json:"start_date"` // 可选，"2006-01-02" 或 "2006-01-02 15:04:05"
+	EndDate   string `json:"end_date"`   // 可选，只给日期时包含当天
+	BeforeID  int    `json:"before_id"`  // 游标：早于该消息
+	Limit     int    `json:"limit"`
+}
+
+// parseSearchTime 解析日期；endOfDay 为 true 且只给日期时取次日 0 点
+func parseSearchTime(s string, endOfDay bool) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, true
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local); err == nil {
+		return t, true
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, true
+}
+
+// searchMessages 搜索 uid 可见的消息，返回结果与下一页游标（0 表示没有更多）
+func searchMessages(ctx context.Context, req *SearchReq) ([]TalkMessage, int, *bizError) {
+	keyword := strings.TrimSpace(req.Keyword)
+	if req.UserID == 0 || keyword == "" || utf8.RuneCountInString(keyword) > searchKeywordMaxLen {
+		return nil, 0, newBizError(400, "参数错误")
+	}
+	from, ok1 := parseSearchTime(req.StartDate, false)
+	to, ok2 := parseSearchTime(req.EndDate, true)
+	if !ok1 || !ok2 {
+		return nil, 0, newBizError(400, "日期格式错误")
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 50 {
+		limit = 50
+	}
+
+	sessions, err := store.Sessions.ListForUser(ctx, req.UserID)
+	if err != nil {
+		return nil, 0, newBizError(500, "查询失败")
+	}
+	var sids []int
+	for _, s := range sessions {
+		if req.SessionID == 0 || s.ID == req.SessionID {
+			sids = append(sids, s.ID)
+		}
+	}
+	if req.SessionID != 0 && len(sids) == 0 {
+		return nil, 0, newBizError(403, "你不在该会话中")
+	}
+	msgTypes := searchableMsgTypes()
+	if req.MsgType != 0 {
+		if !isSearchable(req.MsgType) {
+			return []TalkMessage{}, 0, nil
+		}
+		msgTypes = []int{req.MsgType}
+	}
+	if len(sids) == 0 {
+		return []TalkMessage{}, 0, nil
+	}
+
+	terms := strings.Fields(strings.ToLower(keyword))
+	q := SearchQuery{
+		Terms:    terms,
+		Sids:     sids,
+		SendID:   req.SendID,
+		MsgTypes: msgTypes,
+		From:     from,
+		To:       to,
+		BeforeID: req.BeforeID,
+		Limit:    limit + 1,
+	}
+	// 命中后还要排除撤回 / 仅自己删除的消息，一页不满时继续向前扫描，最多 searchMaxScans 轮；
+	// 游标取最后一条已扫描（而非已返回）的 id，被过滤的行不会在下一页重复出现，
+	// 扫描轮数用尽时返回的条数可能少于 limit，但只要游标非 0 就还有更早的结果
+	list := make([]TalkMessage, 0, limit)
+	nextBeforeID := 0
+	for scan := 1; ; scan++ {
+		ids, err := searchIndex.Search(ctx, q)
+		if err != nil {
+			g.Log().Warningf(ctx, "search failed: %v", err)
+			return nil, 0, newBizError(500, "搜索失败")
+		}
+		more := len(ids) > limit
+		if more {
+			ids = ids[:limit]
+		}
+		visible, bizErr := visibleSearchHits(ctx, req.UserID, ids)
+		if bizErr != nil {
+			return nil, 0, bizErr
+		}
+		scanned := 0
+		for _, id := range ids {
+			scanned++
+			q.BeforeID = id
+			if m, ok := visible[id]; ok {
+				m.Highlight = highlightSnippet(m.Content, terms)
+				list = append(list, m)
+				if len(list) == limit {
+					break
+				}
+			}
+		}
+		if !more && scanned == len(ids) {
+			break // 已扫描到最早的命中
+		}
+		if len(list) == limit || scan >= searchMaxScans {
+			nextBeforeID = q.BeforeID
+			break
+		}
+	}
+	signMessageURLs(list)
+	return list, nextBeforeID, nil
+}
+
+// visibleSearchHits 取出命中的消息，排除撤回与 uid "仅自己删除"的
+func visibleSearchHits(ctx context.Context, uid int, ids []int) (map[int]TalkMessage, *bizError) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	msgs, err := store.Messages.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, newBizError(500, "查询失败")
+	}
+	visible := make(map[int]TalkMessage, len(msgs))
+	for _, m := range withoutHidden(ctx, uid, msgs) {
+		if m.IsRecalled != 1 {
+			visible[m.ID] = m
+		}
+	}
+	return visible, nil
+}
+
+// highlightSnippet 截取首个命中附近的内容，命中部分用 <em></em> 包裹，其余内容做 HTML 转义
+func highlightSnippet(content string, terms []string) string {
+	runes := []rune(content)
+	lower := []rune(strings.ToLower(content))
+	if len(lower) != len(runes) {
+		lower = runes // 大小写转换改变了长度，退化为区分大小写
+	}
+
+	hit := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		t := []rune(term)
+		if len(t) == 0 {
+			continue
+		}
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if string(lower[i:i+len(t)]) != term {
+				continue
+			}
+			for j := i; j < i+len(t); j++ {
+				hit[j] = true
+			}
+			if first < 0 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start := 0
+	if first > searchSnippetLead {
+		start = first - searchSnippetLead
+	}
+	end := start + searchSnippetLen
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; {
+		j := i
+		for j < end && hit[j] == hit[i] {
+			j++
+		}
+		seg := html.EscapeString(string(runes[i:j]))
+		if hit[i] {
+			b.WriteString("<em>" + seg + "</em>")
+		} else {
+			b.WriteString(seg)
+		}
+		i = j
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// 搜索消息
+// POST /talk/message/search
+// body: { "user_id":1, "keyword":"报价", "session_id":0, "send_id":0, "msg_type":0, "start_date":"2024-01-01", "end_date":"2024-01-31", "before_id":0, "limit":20 }
+// 返回 data.list（每条带 highlight）与 data.next_before_id（最后扫描到的消息 id，0 表示没有更多；
+// 过滤掉的消息较多时 list 可能不足 limit 条，以 next_before_id 是否为 0 判断是否到底）
+func searchMessagesHandler(r *ghttp.Request) {
+	var req SearchReq
+	err := r.Parse(&req)
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	list, next, bizErr := searchMessages(r.Context(), &req)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": g.Map{
+		"list":           list,
+		"next_before_id": next,
+	}})
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ---------------------- MySQL FULLTEXT ----------------------
+// 依赖迁移 13 在 message.content 上建立的 ft_message_content（WITH PARSER ngram），
+// 索引随写入由 MySQL 维护，Index / Remove 为空操作。
+// ngram 默认 token 为 2 个字符，单字关键词无法命中。
+
+type mysqlSearchIndex struct{ db *gorm.DB }
+
+func (s *mysqlSearchIndex) Index(context.Context, *TalkMessage) error { return nil }
+
+func (s *mysqlSearchIndex) Remove(context.Context, int) error { return nil }
+
+func (s *mysqlSearchIndex) Search(ctx context.Context, q SearchQuery) ([]int, error) {
+	tx := s.db.WithContext(ctx).Model(&TalkMessage{}).
+		Where("MATCH(content) AGAINST(? IN BOOLEAN MODE)", booleanQuery(q.Terms)).
+		Where("sid IN ? AND msg_type IN ? AND is_recalled=0", q.Sids, q.MsgTypes)
+	if q.SendID > 0 {
+		tx = tx.Where("send_id=?", q.SendID)
+	}
+	if !q.From.IsZero() {
+		tx = tx.Where("created_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("created_at < ?", q.To)
+	}
+	if q.BeforeID > 0 {
+		tx = tx.Where("id < ?", q.BeforeID)
+	}
+	var ids []int
+	err := tx.Order("id desc").Limit(q.Limit).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// booleanQuery 每个关键词作为必须命中的短语，去掉会破坏语法的双引号
+func booleanQuery(terms []string) string {
+	parts := make([]string, 0, len(terms))
+	for _, t := range terms {
+		t = strings.ReplaceAll(t, `"`, "")
+		if t != "" {
+			parts = append(parts, `+"`+t+`"`)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// ---------------------- 进程内索引 ----------------------
+// 单字 + 相邻双字倒排：关键词按双字（单字关键词按单字）求交集得到候选，再做子串校验。
+// 只保存可搜索类型的消息，重启后由 rebuild 从库中重建。
+
+type searchDoc struct {
+	id        int
+	sid       int
+	sendID    int
+	msgType   int
+	createdAt time.Time
+	text      string // 小写后的内容
+}
+
+type memorySearchIndex struct {
+	mu       sync.RWMutex
+	docs     map[int]*searchDoc
+	postings map[string]map[int]bool // 单字/双字 -> 消息 id
+}
+
+func newMemorySearchIndex() *memorySearchIndex {
+	return &memorySearchIndex{
+		docs:     make(map[int]*searchDoc),
+		postings: make(map[string]map[int]bool),
+	}
+}
+
+const searchRebuildBatch = 1000
+
+// rebuild 从库中按 id 分批加载可搜索的消息
+func (s *memorySearchIndex) rebuild(ctx context.Context, messages MessageStore) error {
+	afterID := 0
+	for {
+		batch, err := messages.Scan(ctx, afterID, searchRebuildBatch)
+		if err != nil {
+			return err
+		}
+		for i := range batch {
+			if err := s.Index(ctx, &batch[i]); err != nil {
+				return err
+			}
+		}
+		if len(batch) < searchRebuildBatch {
+			return nil
+		}
+		afterID = batch[len(batch)-1].ID
+	}
+}
+
+// grams 单字与相邻双字
+func grams(text string) []string {
+	r := []rune(text)
+	list := make([]string, 0, 2*len(r))
+	for i := range r {
+		list = append(list, string(r[i]))
+		if i+1 < len(r) {
+			list = append(list, string(r[i:i+2]))
+		}
+	}
+	return list
+}
+
+func (s *memorySearchIndex) Index(ctx context.Context, msg *TalkMessage) error {
+	if !isSearchable(msg.MsgType) || msg.IsRecalled == 1 {
+		return s.Remove(ctx, msg.ID)
+	}
+	doc := &searchDoc{
+		id:        msg.ID,
+		sid:       msg.Sid,
+		sendID:    msg.SendID,
+		msgType:   msg.MsgType,
+		createdAt: msg.CreatedAt,
+		text:      strings.ToLower(msg.Content),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(msg.ID)
+	s.docs[doc.id] = doc
+	for _, gram := range grams(doc.text) {
+		set := s.postings[gram]
+		if set == nil {
+			set = make(map[int]bool)
+			s.postings[gram] = set
+		}
+		set[doc.id] = true
+	}
+	return nil
+}
+
+func (s *memorySearchIndex) Remove(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(id)
+	return nil
+}
+
+func (s *memorySearchIndex) removeLocked(id int) {
+	doc := s.docs[id]
+	if doc == nil {
+		return
+	}
+	for _, gram := range grams(doc.text) {
+		if set := s.postings[gram]; set != nil {
+			delete(set, id)
+			if len(set) == 0 {
+				delete(s.postings, gram)
+			}
+		}
+	}
+	delete(s.docs, id)
+}
+
+// candidates 关键词的候选集合（可能包含误命中，需再做子串校验）
+func (s *memorySearchIndex) candidates(term string) map[int]bool {
+	r := []rune(term)
+	if len(r) == 1 {
+		return s.postings[term]
+	}
+	var result map[int]bool
+	for i := 0; i+1 < len(r); i++ {
+		set := s.postings[string(r[i:i+2])]
+		if len(set) == 0 {
+			return nil
+		}
+		if result == nil || len(set) < len(result) {
+			// 以较小的集合为基准求交集
+			next := make(map[int]bool, len(set))
+			for id := range set {
+				if result == nil || result[id] {
+					next[id] = true
+				}
+			}
+			result = next
+			continue
+		}
+		for id := range result {
+			if !set[id] {
+				delete(result, id)
+			}
+		}
+	}
+	return result
+}
+
+func (s *memorySearchIndex) Search(_ context.Context, q SearchQuery) ([]int, error) {
+	if len(q.Terms) == 0 {
+		return nil, nil
+	}
+	sids := make(map[int]bool, len(q.Sids))
+	for _, sid := range q.Sids {
+		sids[sid] = true
+	}
+	types := make(map[int]bool, len(q.MsgTypes))
+	for _, t := range q.MsgTypes {
+		types[t] = true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var ids []int
+	for id := range s.candidates(q.Terms[0]) {
+		doc := s.docs[id]
+		if doc == nil || !sids[doc.sid] || !types[doc.msgType] {
+			continue
+		}
+		if (q.SendID > 0 && doc.sendID != q.SendID) || (q.BeforeID > 0 && doc.id >= q.BeforeID) {
+			continue
+		}
+		if (!q.From.IsZero() && doc.createdAt.Before(q.From)) || (!q.To.IsZero() && !doc.createdAt.Before(q.To)) {
+			continue
+		}
+		matched := true
+		for _, term := range q.Terms {
+			if !strings.Contains(doc.text, term) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	if q.Limit > 0 && len(ids) > q.Limit {
+		ids = ids[:q.Limit]
+	}
+	return ids, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSearchCursorAfterFilter(t *testing.T) {
+	ctx := context.Background()
+	store = newMemoryStores()
+	searchIndex = newMemorySearchIndex()
+	sess := &TalkSession{SendID: 1, ReceiverID: 2, Status: 1, Type: 1, UpdatedAt: time.Now()}
+	if err := store.Sessions.Create(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 6; i++ {
+		msg := &TalkMessage{Sid: sess.ID, SendID: 2, ReceiverID: 1, MsgType: MsgTypeText, Content: "hello"}
+		if i == 4 || i == 5 {
+			msg.IsRecalled = 1
+		}
+		if err := store.Messages.Create(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+		indexMessage(ctx, msg)
+	}
+	if err := store.Messages.Hide(ctx, 1, []int{3}); err != nil {
+		t.Fatal(err)
+	}
+
+	page := func(before int) ([]int, int) {
+		t.Helper()
+		list, next, bizErr := searchMessages(ctx, &SearchReq{UserID: 1, Keyword: "hello", BeforeID: before, Limit: 2})
+		if bizErr != nil {
+			t.Fatalf("search: %+v", bizErr)
+		}
+		ids := make([]int, 0, len(list))
+		for _, m := range list {
+			ids = append(ids, m.ID)
+		}
+		return ids, next
+	}
+	// 第一页跳过撤回的 5、4 和隐藏的 3 后补满，游标停在最后扫描的 2
+	if ids, next := page(0); len(ids) != 2 || ids[0] != 6 || ids[1] != 2 || next != 2 {
+		t.Fatalf("page 1 = %v next=%d, want [6 2] next=2", ids, next)
+	}
+	if ids, next := page(2); len(ids) != 1 || ids[0] != 1 || next != 0 {
+		t.Fatalf("page 2 = %v next=%d, want [1] next=0", ids, next)
+	}
+}
@@ -8,10 +8,9 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
-	"sync"
 	"time"
 
+	"github.com/glebarez/sqlite"
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/net/ghttp"
 	"github.com/gogf/gf/v2/os/gctx"
@@ -28,11 +27,17 @@ import (
    - nickname (varchar)
    - receiver_id (int)
    - send_id (int)
-   - msg_type (int)
+   - msg_type (int)  见 msgtype.go 注册表
    - avatar (varchar)
-   - content (varchar)
+   - content (varchar)  文本正文；其他类型为预览文本
+   - payload (text, null)  结构化内容 JSON
    - sid (int)  会话ID
    - is_read (int)  1已读,0未读
+   - status (tinyint)  1已发送 2已送达 3已读
+   - client_msg_id (varchar, null)  客户端消息ID，(send_id, client_msg_id) 唯一
+   - is_recalled (tinyint)  1已撤回
+   - edited_at (datetime, null)  最近编辑时间
+   - reply_to_id (int)  引用回复的消息ID，0 表示非回复
    - created_at (timestamp, default CURRENT_TIMESTAMP)
 
 2) 会话表: talk_session
@@ -45,26 +50,42 @@ import (
    - updated_at (datetime) 更新时间
    - send_id (int)       发送人id
    - status (tinyint)    0 隐藏 1 显示
+   - type (tinyint)      1 单聊 2 群聊
 
 3) 用户表: talk_user
    - id (pk, int, ai)
    - username (varchar)
    - user_id (int)
    - user_avatar (varchar)
+   - presence (varchar)  online / away / offline
+   - last_seen_at (datetime, null)  最近一次状态变化时间
 */
 
 // ---------------------- GORM 模型 ----------------------
 type TalkMessage struct {
-	ID         int    `gorm:"primaryKey;column:id" json:"id"`
-	Nickname   string `gorm:"column:nickname" json:"nickname"`
-	ReceiverID int    `gorm:"column:receiver_id" json:"receiver_id"`
-	SendID     int    `gorm:"column:send_id" json:"send_id"`
-	MsgType    int    `gorm:"column:msg_type" json:"msg_type"`
-	Avatar     string `gorm:"column:avatar" json:"avatar"`
-	Content    string `gorm:"column:content" json:"content"`
-	//Sid        int       `gorm:"column:sid" json:"sid"`
-	IsRead    int       `gorm:"column:is_read" json:"is_read"` // 1已读(在线送达), 0未读(离线)
-	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	ID         int       `gorm:"primaryKey;column:id" json:"id"`
+	Nickname   string    `gorm:"column:nickname" json:"nickname"`
+	ReceiverID int       `gorm:"column:receiver_id" json:"receiver_id"`
+	SendID     int       `gorm:"column:send_id" json:"send_id"`
+	MsgType    int       `gorm:"column:msg_type" json:"msg_type"`
+	Avatar     string    `gorm:"column:avatar" json:"avatar"`
+	Content    string    `gorm:"column:content" json:"content"`
+	Sid        int       `gorm:"column:sid" json:"sid"`
+	IsRead     int       `gorm:"column:is_read" json:"is_read"`         // 1已读, 0未读（与 status=3 同步）
+	Status     int       `gorm:"column:status;default:1" json:"status"` // 1已发送 2已送达 3已读
+	CreatedAt  time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+
+	ClientMsgID *string         `gorm:"column:client_msg_id;size:64" json:"client_msg_id,omitempty"` // 客户端消息ID，同一发送者唯一，用于重试去重
+	IsRecalled  int             `gorm:"column:is_recalled" json:"is_recalled"`                       // 1已撤回（内容已清空）
+	EditedAt    *time.Time      `gorm:"column:edited_at" json:"edited_at,omitempty"`                 // 最近一次编辑时间，历史见 message_edit
+	ReplyToID   int             `gorm:"column:reply_to_id" json:"reply_to_id,omitempty"`             // 引用回复的消息ID（同会话）
+	Payload     json.RawMessage `gorm:"column:payload;type:text" json:"payload,omitempty"`           // 按 msg_type 校验的结构化内容，见 msgtype.go
+	ObjectKey   string          `gorm:"column:object_key;size:255" json:"-"`                         // 文件在对象存储中的 key，读取时据此生成 payload.url
+
+	Seq       int64           `gorm:"-" json:"seq,omitempty"`       // 当前用户时间线序号（同步/推送时填充，不落库）
+	Quote     *MessageQuote   `gorm:"-" json:"quote,omitempty"`     // 被引用消息摘要（读取时填充，不落库）
+	Reactions []ReactionCount `gorm:"-" json:"reactions,omitempty"` // 表情回应计数（读取时填充，不落库）
+	Highlight string          `gorm:"-" json:"highlight,omitempty"` // 搜索命中的高亮摘要（搜索时填充，不落库）
 }
 
 func (TalkMessage) TableName() string { return "message" }
@@ -74,141 +95,151 @@ type TalkSession struct {
 	ReceiverID int       `gorm:"column:receiver_id" json:"receiver_id"`
 	IsOnline   int       `gorm:"column:is_online" json:"is_online"` // 1在线 2离线
 	Name       string    `gorm:"column:name" json:"name"`
-	UnReadNum  int       `gorm:"column:un_read_num" json:"un_read_num"`
+	UnReadNum  int       `gorm:"column:un_read_num" json:"un_read_num"` // 单聊为 receiver_id 一方的未读数
 	MsgText    string    `gorm:"column:msg_text" json:"msg_text"`
 	UpdatedAt  time.Time `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
 	SendID     int       `gorm:"column:send_id" json:"send_id"`
 	Status     int       `gorm:"column:status;default:1" json:"status"` // 0隐藏 1显示
+	Type       int       `gorm:"column:type;default:1" json:"type"`     // 1单聊 2群聊（成员见 session_member）
+
+	SendUnReadNum int `gorm:"column:send_un_read_num" json:"-"` // 单聊 send_id 一方的未读数，返回前按查看者填入 un_read_num
+
+	Presence   string     `gorm:"-" json:"presence,omitempty"`     // 单聊对方的在线状态（读取时填充，不落库）
+	LastSeenAt *time.Time `gorm:"-" json:"last_seen_at,omitempty"` // 单聊对方最近在线时间
 }
 
 func (TalkSession) TableName() string { return "session" }
 
+// viewedBy 单聊会话按查看者填充 un_read_num（群聊未读数在 session_member）
+func (s TalkSession) viewedBy(uid int) TalkSession {
+	if s.Type != SessionTypeGroup && uid == s.SendID && uid != s.ReceiverID {
+		s.UnReadNum = s.SendUnReadNum
+	}
+	return s
+}
+
 type TalkUser struct {
-	ID         int    `gorm:"primaryKey;column:id" json:"id"`
-	Username   string `gorm:"column:username" json:"username"`
-	UserID     int    `gorm:"column:user_id" json:"user_id"`
-	UserAvatar string `gorm:"column:user_avatar" json:"user_avatar"`
+	ID         int        `gorm:"primaryKey;column:id" json:"id"`
+	Username   string     `gorm:"column:username" json:"username"`
+	UserID     int        `gorm:"column:user_id" json:"user_id"`
+	UserAvatar string     `gorm:"column:user_avatar" json:"user_avatar"`
+	Presence   string     `gorm:"column:presence;size:16;default:offline" json:"presence"` // online / away / offline
+	LastSeenAt *time.Time `gorm:"column:last_seen_at" json:"last_seen_at"`                 // 最近一次状态变化（上线/离开/下线）时间
 }
 
 func (TalkUser) TableName() string { return "users" }
 
 // ---------------------- WebSocket 相关 ----------------------
-type Client struct {
-	Conn      *websocket.Conn
-	UserID    int
-	Name      string
-	SendCh    chan []byte
-	LastPong  time.Time
-	Heartbeat time.Duration
-	FirstPing bool
-}
-
 var (
-	upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	upgrader = websocket.Upgrader{} // 由 initUpgrader 按配置初始化
 
-	clientsByConn   = make(map[*websocket.Conn]*Client)
-	clientsByUserID = make(map[int]*Client)
-	clientsMu       sync.Mutex
+	hub = NewHub()
 
 	db *gorm.DB
 )
 
 // ---------------------- 初始化数据库 ----------------------
+// database.default.driver 选择后端：
+//   - mysql（默认）：link 为 MySQL DSN，或环境变量 MYSQL_DSN
+//   - sqlite：link 为数据库文件路径（纯 Go 实现，无需 CGO），本地开发 / CI 离线运行
 func initDB(ctx context.Context) {
-	// 优先用配置文件 database.default.link；否则用环境变量 MYSQL_DSN
-	dsn := g.Cfg().MustGet(ctx, "database.default.link").String()
-	if dsn == "" {
-		dsn = os.Getenv("MYSQL_DSN")
+	driver := g.Cfg().MustGet(ctx, "database.default.driver", "mysql").String()
+	if v := os.Getenv("DB_DRIVER"); v != "" {
+		driver = v
 	}
-	if dsn == "" {
-		panic("missing database dsn: set config \"database.default.link\" or env MYSQL_DSN")
+	dsn := g.Cfg().MustGet(ctx, "database.default.link").String()
+
+	var dialector gorm.Dialector
+	switch driver {
+	case "mysql":
+		// 优先用配置文件 database.default.link；否则用环境变量 MYSQL_DSN
+		if dsn == "" {
+			dsn = os.Getenv("MYSQL_DSN")
+		}
+		if dsn == "" {
+			panic("missing database dsn: set config \"database.default.link\" or env MYSQL_DSN")
+		}
+		dialector = mysql.Open(dsn)
+	case "sqlite":
+		if v := os.Getenv("SQLITE_PATH"); v != "" {
+			dsn = v
+		}
+		if dsn == "" {
+			dsn = "data/chat.db"
+		}
+		if dir := filepath.Dir(dsn); dsn != ":memory:" && dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				panic("failed to create sqlite dir: " + err.Error())
+			}
+		}
+		dialector = sqlite.Open(dsn + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	default:
+		panic("unsupported database driver: " + driver)
 	}
 
 	var err error
-	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	db, err = gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
 		panic("failed to connect database: " + err.Error())
 	}
-
-	// 仅确保表存在（不会破坏已有字段约束）
-	_ = db.AutoMigrate(&TalkMessage{}, &TalkSession{}, &TalkUser{})
-}
-
-// ---------------------- WebSocket 心跳 & 读写 ----------------------
-func sendWS(c *Client, payload any) {
-	data, _ := json.Marshal(payload)
-	select {
-	case c.SendCh <- data:
-	default:
-		// 下游阻塞，关闭连接回收
-		close(c.SendCh)
-		clientsMu.Lock()
-		delete(clientsByConn, c.Conn)
-		delete(clientsByUserID, c.UserID)
-		clientsMu.Unlock()
-		_ = c.Conn.Close()
+	if driver == "sqlite" {
+		// SQLite 单写者，串行化连接避免 database is locked
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.SetMaxOpenConns(1)
+		}
 	}
+
+	store = newGormStores(db)
 }
 
 // GET /user/list
 func userListHandler(r *ghttp.Request) {
-	var users []TalkUser
-	if err := db.Find(&users).Error; err != nil {
+	users, err := store.Users.List(r.Context())
+	if err != nil {
 		r.Response.WriteJsonExit(g.Map{"code": 500, "msg": "查询失败"})
 		return
 	}
 
 	// 组装返回：附带在线状态
 	type UserDTO struct {
-		ID         int    `json:"id"`
-		UserID     int    `json:"user_id"`
-		Username   string `json:"username"`
-		UserAvatar string `json:"user_avatar"`
-		IsOnline   int    `json:"is_online"` // 1在线 2离线
+		ID         int        `json:"id"`
+		UserID     int        `json:"user_id"`
+		Username   string     `json:"username"`
+		UserAvatar string     `json:"user_avatar"`
+		IsOnline   int        `json:"is_online"` // 1在线（含离开） 2离线
+		Presence   string     `json:"presence"`  // online / away / offline
+		LastSeenAt *time.Time `json:"last_seen_at"`
 	}
 	res := make([]UserDTO, 0, len(users))
 
-	clientsMu.Lock()
+	uids := make([]int, len(users))
+	for i := range users {
+		uids[i] = users[i].UserID
+	}
+	online := cluster.OnlineSet(uids)
 	for _, u := range users {
-		isOnline := 2
-		if _, ok := clientsByUserID[u.UserID]; ok {
-			isOnline = 1
-		}
+		p := resolvePresence(&u, online[u.UserID])
 		res = append(res, UserDTO{
 			ID:         u.ID,
 			UserID:     u.UserID,
 			Username:   u.Username,
 			UserAvatar: u.UserAvatar,
-			IsOnline:   isOnline,
+			IsOnline:   p.isOnline(),
+			Presence:   p.Status,
+			LastSeenAt: p.LastSeenAt,
 		})
 	}
-	clientsMu.Unlock()
 
 	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": res})
 }
 
-func broadcastPresence(uid int, online bool) {
-	payload := map[string]any{
-		"event": "user_presence",
-		"data": map[string]any{
-			"user_id": uid,
-			"online":  online, // true=在线, false=离线
-		},
-	}
-	clientsMu.Lock()
-	defer clientsMu.Unlock()
-	for _, cl := range clientsByUserID {
-		sendWS(cl, payload)
-	}
-}
+// ---------------------- WebSocket 心跳 & 读写 ----------------------
 func readPump(c *Client) {
 	defer func() {
-		clientsMu.Lock()
-		delete(clientsByConn, c.Conn)
-		delete(clientsByUserID, c.UserID)
-		clientsMu.Unlock()
-		_ = c.Conn.Close()
-		//broadcastPresence(c.UserID, false)
+		c.Close("read closed")
+		hub.Unregister(c)
+		cluster.Leave(c)
+		presence.Disconnected(gctx.New(), c)
 	}()
 
 	c.Conn.SetPongHandler(func(appData string) error {
@@ -216,21 +247,17 @@ func readPump(c *Client) {
 		return nil
 	})
 
-	type InMsg struct {
-		Event string          `json:"event"`
-		Data  json.RawMessage `json:"data"`
-	}
-
 	for {
 		_, raw, err := c.Conn.ReadMessage()
 		if err != nil {
 			return
 		}
 
-		var in InMsg
+		var in wsFrame
 		if err := json.Unmarshal(raw, &in); err != nil {
 			continue
 		}
+		ctx := gctx.New()
 
 		switch in.Event {
 		case "ping":
@@ -243,6 +270,8 @@ func readPump(c *Client) {
 					"event": "connect",
 					"content": map[string]any{
 						"message":       "连接成功",
+						"device_id":     c.DeviceID,
+						"protocol":      c.Protocol,
 						"ping_interval": int(c.Heartbeat.Seconds()),           // 30
 						"ping_timeout":  int((c.Heartbeat * 5 / 2).Seconds()), // 75
 					},
@@ -252,35 +281,22 @@ func readPump(c *Client) {
 			}
 			c.LastPong = time.Now()
 
-			// 这里可以扩展更多 ws 侧业务事件，但当前消息发送走 HTTP
-		}
-	}
-}
-
-func writePump(c *Client) {
-	ticker := time.NewTicker(c.Heartbeat)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case msg, ok := <-c.SendCh:
-			_ = c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				_ = c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-			if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-				return
-			}
-		case <-ticker.C:
-			_ = c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				return
-			}
-			// 心跳 2 倍未收到 pong 认为断开
-			if time.Since(c.LastPong) > c.Heartbeat*2 {
-				return
-			}
+		case "im.send":
+			wsSendMessage(ctx, c, &in)
+		case "im.read":
+			wsMarkRead(ctx, c, &in)
+		case "im.ack":
+			wsAckDelivered(ctx, c, &in)
+		case "im.sync":
+			wsSyncMessages(ctx, c, &in)
+		case "typing.start", "typing.stop":
+			wsTyping(ctx, c, &in)
+		case "presence.set":
+			wsPresenceSet(ctx, c, &in)
+		case "presence.subscribe":
+			wsPresenceSubscribe(ctx, c, &in)
+		case "presence.unsubscribe":
+			wsPresenceUnsubscribe(ctx, c, &in)
 		}
 	}
 }
@@ -296,7 +312,9 @@ func createSessionHandler(r *ghttp.Request) {
 		ReceiverID int    `json:"receiver_id"`
 		Name       string `json:"name"`
 	}
-	if err := r.Parse(&req); err != nil || req.SendID == 0 || req.ReceiverID == 0 {
+	err := r.Parse(&req)
+	req.SendID = authUID(r, req.SendID) // 以令牌身份为准
+	if err != nil || req.SendID == 0 || req.ReceiverID == 0 {
 		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
 		return
 	}
@@ -307,9 +325,10 @@ func createSessionHandler(r *ghttp.Request) {
 		Name:       req.Name,
 		IsOnline:   2,
 		Status:     1,
+		Type:       SessionTypeSingle,
 		UpdatedAt:  time.Now(),
 	}
-	if err := db.Create(s).Error; err != nil {
+	if err := store.Sessions.Create(r.Context(), s); err != nil {
 		r.Response.WriteJsonExit(g.Map{"code": 500, "msg": "创建会话失败"})
 		return
 	}
@@ -322,322 +341,174 @@ func sessionListHandler(r *ghttp.Request) {
 	var req struct {
 		UserID int `json:"id"`
 	}
-	if err := r.Parse(&req); err != nil || req.UserID == 0 {
+	err := r.Parse(&req)
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if err != nil || req.UserID == 0 {
 		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
 		return
 	}
 
-	var list []TalkSession
-	if err := db.Where("status=1 AND (receiver_id=? OR send_id=?)", req.UserID, req.UserID).
-		Order("updated_at desc").Find(&list).Error; err != nil {
+	list, err := store.Sessions.ListForUser(r.Context(), req.UserID)
+	if err != nil {
 		r.Response.WriteJsonExit(g.Map{"code": 500, "msg": "查询失败"})
 		return
 	}
 
-	// 标注在线状态（基于 WS 内存）
-	clientsMu.Lock()
-	for i := range list {
-		if _, ok := clientsByUserID[list[i].ReceiverID]; ok {
-			list[i].IsOnline = 1
-		} else {
-			list[i].IsOnline = 2
-		}
-	}
-	clientsMu.Unlock()
+	fillSessionPresence(r.Context(), req.UserID, list)
 
 	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": list})
 }
 
-// 消息列表
-// GET /talk/message/list?sid=1001&page=1&size=20
+// 消息列表（游标分页）
+// GET /talk/message/list?sid=1001&user_id=1&before_id=5000&limit=20（仅会话参与者可查看）
+//   - before_id：取比它更早的消息（id 倒序），用于向上翻历史；不传则取最新
+//   - after_id ：取比它更新的消息（id 正序），用于补齐新消息
 func messageListHandler(r *ghttp.Request) {
 	var req struct {
-		SessionID int `json:"id"`   // 会话ID
-		Page      int `json:"page"` // 页码
-		Size      int `json:"size"` // 每页大小
+		SessionID int `json:"id"`        // 会话ID
+		Sid       int `json:"sid"`       // 会话ID（同 id，兼容 ?sid= 写法）
+		UserID    int `json:"user_id"`   // 查看者，过滤其"仅自己删除"的消息
+		BeforeID  int `json:"before_id"` // 游标：早于该消息
+		AfterID   int `json:"after_id"`  // 游标：晚于该消息
+		Limit     int `json:"limit"`     // 条数
+		Size      int `json:"size"`      // 条数（旧参数，同 limit）
 	}
 
-	if err := r.Parse(&req); err != nil || req.SessionID == 0 {
+	if err := r.Parse(&req); err == nil && req.SessionID == 0 {
+		req.SessionID = req.Sid
+	}
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if req.SessionID == 0 {
 		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
 		return
 	}
+	if bizErr := checkParticipant(r.Context(), req.SessionID, req.UserID); bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
 
-	if req.Page <= 0 {
-		req.Page = 1
+	if req.Limit <= 0 {
+		req.Limit = req.Size
+	}
+	if req.Limit <= 0 {
+		req.Limit = 20
 	}
-	if req.Size <= 0 {
-		req.Size = 20
+	if req.Limit > 100 {
+		req.Limit = 100
 	}
-	offset := (req.Page - 1) * req.Size
 
-	var msgs []TalkMessage
-	if err := db.Where("sid = ?", req.SessionID).
-		Order("id desc").
-		Limit(req.Size).
-		Offset(offset).
-		Find(&msgs).Error; err != nil {
+	// 多取一条判断是否还有更多
+	msgs, err := store.Messages.List(r.Context(), MessageQuery{
+		Sid:      req.SessionID,
+		BeforeID: req.BeforeID,
+		AfterID:  req.AfterID,
+		Limit:    req.Limit + 1,
+	})
+	if err != nil {
 		r.Response.WriteJsonExit(g.Map{"code": 500, "msg": "查询失败"})
 		return
 	}
+	hasMore := len(msgs) > req.Limit
+	if hasMore {
+		msgs = msgs[:req.Limit]
+	}
+	msgs = withoutHidden(r.Context(), req.UserID, msgs)
+	fillQuotes(r.Context(), msgs)
+	fillReactions(r.Context(), req.UserID, msgs)
+	signMessageURLs(msgs)
 
-	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": msgs})
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": msgs, "has_more": hasMore})
 }
 
 // 发送消息（HTTP）
 // POST /talk/message/send
-// body: { "session_id":1001, "send_id":1, "receiver_id":2, "msg_type":1, "content":"你好", "nickname":"张三", "avatar":"https://..." }
+// body: { "session_id":1001, "send_id":1, "receiver_id":2, "msg_type":1, "content":"你好", "nickname":"张三", "avatar":"https://...", "client_msg_id":"c-uuid"(可选), "reply_to_id":5000(可选), "payload":{...}(可选) }
+// 非文本消息传 payload（字段见 GET /talk/message/types），content 可不传；只传 content 时按类型转换（文本为正文，媒体为 URL）
 func sendMessageHandler(r *ghttp.Request) {
-	var req struct {
-		SessionID  int    `json:"session_id"`
-		SendID     int    `json:"send_id"`
-		ReceiverID int    `json:"receiver_id"`
-		MsgType    int    `json:"msg_type"`
-		Content    string `json:"content"`
-		Nickname   string `json:"nickname"`
-		Avatar     string `json:"avatar"`
-	}
-	if err := r.Parse(&req); err != nil ||
-		req.SessionID == 0 || req.SendID == 0 || req.ReceiverID == 0 || req.MsgType == 0 || req.Content == "" {
+	var req SendMessageReq
+	if err := r.Parse(&req); err != nil {
 		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
 		return
 	}
-	// —— 新增：会话归属校验 —— //
-	var sess TalkSession
-	if err := db.First(&sess, "id=?", req.SessionID).Error; err != nil {
-		r.Response.WriteJsonExit(g.Map{"code": 404, "msg": "会话不存在"})
-		return
-	}
-
-	// 发送者必须在会话里
-	if req.SendID != sess.SendID && req.SendID != sess.ReceiverID {
-		r.Response.WriteJsonExit(g.Map{"code": 403, "msg": "你不在该会话中"})
-		return
-	}
-	// 接收者必须是会话里除自己以外的那一方
-	expectedReceiver := sess.SendID
-	if req.SendID == sess.SendID {
-		expectedReceiver = sess.ReceiverID
-	}
-	if req.ReceiverID != expectedReceiver {
-		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "接收者与会话不匹配"})
-		return
-	}
-	// 先写库（默认未读）
-	msg := &TalkMessage{
-		//Sid:        req.SessionID,
-		SendID:     req.SendID,
-		ReceiverID: req.ReceiverID,
-		MsgType:    req.MsgType,
-		Content:    req.Content,
-		Nickname:   req.Nickname,
-		Avatar:     req.Avatar,
-		IsRead:     0,
-	}
-	if err := db.Create(msg).Error; err != nil {
-		r.Response.WriteJsonExit(g.Map{"code": 500, "msg": "保存消息失败"})
+	req.SendID = authUID(r, req.SendID) // 以令牌身份为准
+	msg, bizErr := sendMessage(r.Context(), &req)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
 		return
 	}
 
-	// 更新会话最后消息 & 未读
-	online := false
-	clientsMu.Lock()
-	rc, ok := clientsByUserID[req.ReceiverID]
-	if ok && rc != nil {
-		online = true
-	}
-	clientsMu.Unlock()
-
-	sessionUpdate := map[string]any{
-		"msg_text":   req.Content,
-		"updated_at": time.Now(),
-	}
-	if !online {
-		sessionUpdate["un_read_num"] = gorm.Expr("un_read_num + 1")
-	}
-	_ = db.Model(&TalkSession{}).Where("id=?", req.SessionID).Updates(sessionUpdate).Error
-
-	// 若对方在线：经 WS 推送，并将该条消息置为已读
-	if online {
-		msg.IsRead = 1
-		_ = db.Model(msg).Update("is_read", 1).Error
-
-		push := map[string]any{
-			"event": "im.message",
-			"sid":   req.SessionID,
-			"content": map[string]any{
-				"data": map[string]any{
-					"id":          msg.ID,
-					"session_id":  req.SessionID,
-					"send_id":     req.SendID,
-					"receiver_id": req.ReceiverID,
-					"nickname":    req.Nickname,
-					"avatar":      req.Avatar,
-					"msg_type":    msg.MsgType,
-					"content":     msg.Content,
-					"created_at":  msg.CreatedAt.Format("2006-01-02 15:04:05"),
-					"is_read":     1,
-				},
-				"receiver_id": req.ReceiverID,
-				"send_id":     req.SendID,
-			},
-		}
-		sendWS(rc, push)
-	}
-	// —— 新增：推送最新会话信息给双方 —— //
-	var fresh TalkSession
-	_ = db.First(&fresh, "id=?", req.SessionID).Error
-
-	clientsMu.Lock()
-	sc := clientsByUserID[req.SendID]
-	rc2 := clientsByUserID[req.ReceiverID]
-	clientsMu.Unlock()
-
-	if sc != nil {
-		sendWS(sc, map[string]any{"event": "session_updated", "data": fresh})
-	}
-	if rc2 != nil {
-		sendWS(rc2, map[string]any{"event": "session_updated", "data": fresh})
-	}
-
 	// —— 返回结果 —— //
 	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": msg})
 }
-func upsertUser(uid int, name, avatar string) {
-	var u TalkUser
-	err := db.Where("user_id = ?", uid).First(&u).Error
-	switch {
-	case err == nil:
-		// 如昵称/头像有变化则更新
-		needUpdate := false
-		update := map[string]any{}
-		if name != "" && name != u.Username {
-			update["username"] = name
-			needUpdate = true
-		}
-		if avatar != "" && avatar != u.UserAvatar {
-			update["user_avatar"] = avatar
-			needUpdate = true
-		}
-		if needUpdate {
-			_ = db.Model(&TalkUser{}).Where("id=?", u.ID).Updates(update).Error
-		}
-	case err == gorm.ErrRecordNotFound:
-		// 不存在就创建
-		_ = db.Create(&TalkUser{
-			Username:   name,
-			UserID:     uid,
-			UserAvatar: avatar,
-		}).Error
-	default:
-		// 其他错误忽略
-	}
-}
 
 // 上传文件
 // POST /upload/file  (multipart/form-data)
-// fields: file, send_id, receiver_id, msg_type, session_id, nickname(可选), avatar(可选)
+// fields: file, send_id, receiver_id(群聊可不传), msg_type, session_id, nickname(可选), avatar(可选)
 func uploadHandler(r *ghttp.Request) {
 	file := r.GetUploadFile("file")
 	if file == nil {
 		r.Response.WriteJsonExit(g.Map{"code": 400, "message": "缺少文件"})
 		return
 	}
-	sendID := r.Get("send_id").Int()
+	sendID := authUID(r, r.Get("send_id").Int()) // 以令牌身份为准
 	receiverID := r.Get("receiver_id").Int()
 	msgType := r.Get("msg_type").Int()
 	sessionID := r.Get("session_id").Int()
 	nickname := r.Get("nickname").String()
 	avatar := r.Get("avatar").String()
-	if sendID == 0 || receiverID == 0 || msgType == 0 || sessionID == 0 {
+	if sendID == 0 || msgType == 0 || sessionID == 0 {
 		r.Response.WriteJsonExit(g.Map{"code": 400, "message": "参数不完整"})
 		return
 	}
-
-	baseDir := "static/uploads/"
-	if err := os.MkdirAll(baseDir, 0755); err != nil {
-		r.Response.WriteJsonExit(g.Map{"code": 500, "message": "创建目录失败"})
+	// 大文件请使用分片上传（见 upload.go）
+	if bizErr := checkUploadSize(msgType, file.Size); bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "message": bizErr.Msg})
 		return
 	}
-	timestampDir := strconv.FormatInt(time.Now().Unix(), 10)
-	savePath := filepath.Join(baseDir, timestampDir)
-	if err := os.MkdirAll(savePath, 0755); err != nil {
-		r.Response.WriteJsonExit(g.Map{"code": 500, "message": "创建目录失败"})
+
+	ctx := r.Context()
+	src, err := file.Open()
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "message": "读取文件失败"})
 		return
 	}
-	savedFileName, err := file.Save(savePath)
-	if err != nil {
+	defer src.Close()
+	mime := file.Header.Get("Content-Type")
+	key := objectKey(msgType, newUploadID(), file.Filename)
+	if err := objectStorage.Put(ctx, key, src, file.Size, mime); err != nil {
+		g.Log().Warningf(ctx, "put object failed, key=%s: %v", key, err)
 		r.Response.WriteJsonExit(g.Map{"code": 500, "message": "保存失败"})
 		return
 	}
-	fileURL := fmt.Sprintf("/uploads/%s/%s", timestampDir, savedFileName)
 
-	// 以文件URL作为消息内容
-	msg := &TalkMessage{
-		//Sid:        sessionID,
+	// 以文件信息作为 payload，与普通消息走同一套校验/落库/推送（含群聊）；类型未声明的字段会被丢弃
+	payload, _ := json.Marshal(g.Map{
+		"url":  objectPath(key),
+		"name": file.Filename,
+		"size": file.Size,
+		"mime": mime,
+	})
+	msg, bizErr := sendMessage(ctx, &SendMessageReq{
+		SessionID:  sessionID,
 		SendID:     sendID,
 		ReceiverID: receiverID,
 		MsgType:    msgType,
-		Content:    fileURL,
+		Payload:    payload,
 		Nickname:   nickname,
 		Avatar:     avatar,
-		IsRead:     0,
-	}
-	if err := db.Create(msg).Error; err != nil {
-		r.Response.WriteJsonExit(g.Map{"code": 500, "message": "保存消息失败"})
+		ObjectKey:  key,
+	})
+	if bizErr != nil {
+		_ = objectStorage.Delete(ctx, key)
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "message": bizErr.Msg})
 		return
 	}
 
-	// 会话更新
-	online := false
-	clientsMu.Lock()
-	rc, ok := clientsByUserID[receiverID]
-	if ok && rc != nil {
-		online = true
-	}
-	clientsMu.Unlock()
-
-	update := map[string]any{
-		"msg_text":   fileURL,
-		"updated_at": time.Now(),
-	}
-	if !online {
-		update["un_read_num"] = gorm.Expr("un_read_num + 1")
-	}
-	_ = db.Model(&TalkSession{}).Where("id=?", sessionID).Updates(update).Error
-
-	// 在线则推送
-	if online {
-		msg.IsRead = 1
-		_ = db.Model(msg).Update("is_read", 1).Error
-
-		push := map[string]any{
-			"event": "im.message",
-			"sid":   sessionID,
-			"content": map[string]any{
-				"data": map[string]any{
-					"id":          msg.ID,
-					"session_id":  sessionID,
-					"send_id":     sendID,
-					"receiver_id": receiverID,
-					"nickname":    nickname,
-					"avatar":      avatar,
-					"msg_type":    msgType,
-					"content":     fileURL,
-					"created_at":  msg.CreatedAt.Format("2006-01-02 15:04:05"),
-					"is_read":     1,
-				},
-				"receiver_id": receiverID,
-				"send_id":     sendID,
-			},
-		}
-		sendWS(rc, push)
-	}
-
 	r.Response.WriteJsonExit(g.Map{
 		"code":    0,
 		"message": "上传成功",
 		"data": g.Map{
-			"file_url":   fileURL,
+			"file_url":   fileURLOf(msg),
 			"msg_id":     msg.ID,
 			"created_at": msg.CreatedAt.Format("2006-01-02 15:04:05"),
 		},
@@ -646,247 +517,219 @@ func uploadHandler(r *ghttp.Request) {
 
 // ---------------------- WebSocket Handler ----------------------
 func wsHandler(r *ghttp.Request) {
-	ws, err := upgrader.Upgrade(r.Response.Writer, r.Request, nil)
+	// 握手策略：Origin 白名单 / 子协议
+	if reason := wsPolicy.check(r.Request); reason != "" {
+		logRejectedUpgrade(r.Request, reason)
+		r.Response.WriteStatus(http.StatusForbidden, reason)
+		return
+	}
+
+	// 握手前鉴权：/ws?token=xxx
+	ident, err := authenticate(r)
 	if err != nil {
-		r.Response.WriteStatus(http.StatusBadRequest, err.Error())
+		logRejectedUpgrade(r.Request, "auth: "+err.Error())
+		r.Response.WriteStatus(http.StatusUnauthorized, err.Error())
 		return
 	}
 
-	uid := r.Get("id").Int()
-	if uid == 0 {
-		uid = int(time.Now().UnixNano() / 1e6)
+	ws, err := upgrader.Upgrade(r.Response.Writer, r.Request, nil)
+	if err != nil {
+		// Upgrade 失败时 gorilla 已写回错误响应
+		logRejectedUpgrade(r.Request, err.Error())
+		return
 	}
+
+	var uid int
 	name := r.Get("name").String()
+	avatar := r.Get("avatar").String()
+	if ident != nil {
+		uid = ident.UserID
+		if ident.Name != "" {
+			name = ident.Name
+		}
+		if ident.Avatar != "" {
+			avatar = ident.Avatar
+		}
+	} else {
+		// 未开启鉴权（本地调试）：沿用 id 参数
+		uid = r.Get("id").Int()
+		if uid == 0 {
+			uid = int(time.Now().UnixNano() / 1e6)
+		}
+	}
 	if name == "" {
 		name = fmt.Sprintf("U%d", uid)
 	}
-	avatar := r.Get("avatar").String()
+	// 设备标识：同一设备重复连接会顶掉旧连接；未传则每个连接视为独立设备
+	deviceID := r.Get("device_id").String()
+	if deviceID == "" {
+		deviceID = fmt.Sprintf("d%d", time.Now().UnixNano())
+	}
 
 	// ★ 先把用户写入/更新到 users 表
-	upsertUser(uid, name, avatar)
+	_ = store.Users.Upsert(r.Context(), uid, name, avatar)
 
 	c := &Client{
 		Conn:      ws,
 		UserID:    uid,
+		DeviceID:  deviceID,
+		Protocol:  ws.Subprotocol(),
 		Name:      name,
-		SendCh:    make(chan []byte, 256),
+		queue:     newSendQueue(),
+		done:      make(chan struct{}),
 		LastPong:  time.Now(),
 		Heartbeat: 30 * time.Second,
 		FirstPing: true,
 	}
 
-	clientsMu.Lock()
-	clientsByConn[ws] = c
-	clientsByUserID[uid] = c
-	clientsMu.Unlock()
+	if old := hub.Register(c); old != nil {
+		// 同设备重复登录，关闭旧连接（其 readPump 退出时 Unregister 不会误删新连接）
+		old.Close("replaced by new connection")
+	}
+	cluster.Join(c)
 
-	// 上线广播给所有在线用户（可选）
-	//broadcastPresence(uid, true)
+	// 上线：更新状态并通知订阅者
+	presence.Connected(r.Context(), c)
 
-	// 上线即推送会话列表并同步离线未读消息
-	//pushSessionListTo(uid)
-	go deliverUnread(c)
+	// 上线即推送会话列表；离线消息由客户端 im.sync 按 seq 增量拉取
+	//pushSessionListTo(r.Context(), uid)
 
 	go writePump(c)
 	readPump(c) // 阻塞到断开
 }
 
 // —— 新增：推送某用户的会话列表 —— //
-func pushSessionListTo(uid int) {
-	var list []TalkSession
-	if err := db.Where("status=1 AND (receiver_id=? OR send_id=?)", uid, uid).
-		Order("updated_at desc").Find(&list).Error; err != nil {
+func pushSessionListTo(ctx context.Context, uid int) {
+	list, err := store.Sessions.ListForUser(ctx, uid)
+	if err != nil {
 		return
 	}
 
-	clientsMu.Lock()
-	// 标注在线状态
-	for i := range list {
-		if _, ok := clientsByUserID[list[i].ReceiverID]; ok {
-			list[i].IsOnline = 1
-		} else {
-			list[i].IsOnline = 2
-		}
-	}
-	c := clientsByUserID[uid]
-	clientsMu.Unlock()
+	fillSessionPresence(ctx, uid, list)
 
-	if c != nil {
-		sendWS(c, map[string]any{"event": "session_list", "data": list})
-	}
+	cluster.Push(uid, map[string]any{"event": "session_list", "data": list})
 }
 
-// —— 新增：把该用户的未读消息标记为已读并一次性推送给他 —— //
-func deliverUnread(c *Client) {
-	var msgs []TalkMessage
-	if err := db.Where("receiver_id=? AND is_read=0", c.UserID).
-		Order("id asc").Find(&msgs).Error; err == nil && len(msgs) > 0 {
-
-		// 置为已读
-		_ = db.Model(&TalkMessage{}).
-			Where("receiver_id=? AND is_read=0", c.UserID).
-			Update("is_read", 1).Error
-
-		// 会话未读清零
-		_ = db.Model(&TalkSession{}).
-			Where("receiver_id=?", c.UserID).
-			Update("un_read_num", 0).Error
-
-		for _, msg := range msgs {
-			// 找到对应会话
-			var sess TalkSession
-			_ = db.Where("(send_id=? AND receiver_id=?) OR (send_id=? AND receiver_id=?)",
-				msg.SendID, msg.ReceiverID, msg.ReceiverID, msg.SendID).First(&sess).Error
-
-			push := map[string]any{
-				"event": "im.message",
-				"sid":   sess.ID,
-				"content": map[string]any{
-					"data": map[string]any{
-						"id":          msg.ID,
-						"session_id":  sess.ID,
-						"send_id":     msg.SendID,
-						"receiver_id": msg.ReceiverID,
-						"nickname":    msg.Nickname,
-						"avatar":      msg.Avatar,
-						"msg_type":    msg.MsgType,
-						"content":     msg.Content,
-						"created_at":  msg.CreatedAt.Format("2006-01-02 15:04:05"),
-						"is_read":     1,
-					},
-					"receiver_id": msg.ReceiverID,
-					"send_id":     msg.SendID,
-				},
-			}
-			sendWS(c, push)
-
-		}
+// findPairSession a、b 之间的单聊会话：先按 a→b 查，再按 b→a 查，与 backfillSessionOf 的规则一致
+func findPairSession(ctx context.Context, st *Stores, a, b int) (*TalkSession, error) {
+	sess, err := st.Sessions.FindPair(ctx, a, b)
+	if err != nil {
+		sess, err = st.Sessions.FindPair(ctx, b, a)
 	}
+	return sess, err
 }
 
+// 发起复核报价，向接收方发送报价卡片（msg_type=1001）
+// POST /talk/review
+// body: { "send_id":1, "send_name":"A", "receiver_id":2, "receiver_name":"B", "items":[{"name":"安装费","quantity":1,"unit_price":50000}], "currency":"CNY", "note":"", "valid_hours":72 }
 func reviewHandler(r *ghttp.Request) {
 	var req struct {
 		SendID       int    `json:"send_id"`
 		SendName     string `json:"send_name"`
 		ReceiverID   int    `json:"receiver_id"`
 		ReceiverName string `json:"receiver_name"`
+		QuoteReq
 	}
-	if err := r.Parse(&req); err != nil || req.SendID == 0 || req.ReceiverID == 0 {
+	err := r.Parse(&req)
+	req.SendID = authUID(r, req.SendID) // 以令牌身份为准
+	if err != nil || req.SendID == 0 || req.ReceiverID == 0 || req.SendID == req.ReceiverID {
 		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
 		return
 	}
+	quote, bizErr := newQuote(req.SendID, req.ReceiverID, &req.QuoteReq)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
 
+	ctx := r.Context()
 	now := time.Now()
+	var msg *TalkMessage
+
+	// 会话、报价、消息、时间线与待推送事件在同一事务内写入
+	err = store.Tx(ctx, func(st *Stores) error {
+		// --- 获取或创建双方共用的单聊会话（任一方向已存在即复用） ---
+		sess, err := findPairSession(ctx, st, req.SendID, req.ReceiverID)
+		if err != nil {
+			sess = &TalkSession{
+				SendID:     req.SendID,
+				ReceiverID: req.ReceiverID,
+				Name:       req.ReceiverName,
+				IsOnline:   2,
+				Status:     1,
+				Type:       SessionTypeSingle,
+				UpdatedAt:  now,
+			}
+			if err := st.Sessions.Create(ctx, sess); err != nil {
+				return err
+			}
+		}
 
-	// --- 获取或创建发送者会话 ---
-	var sendSession TalkSession
-	if err := db.Where("send_id=? AND receiver_id=?", req.SendID, req.ReceiverID).First(&sendSession).Error; err != nil {
-		sendSession = TalkSession{
+		// --- 创建报价与卡片消息 ---
+		if err := st.Quotes.Create(ctx, quote); err != nil {
+			return err
+		}
+		msg = &TalkMessage{
+			Sid:        sess.ID,
 			SendID:     req.SendID,
 			ReceiverID: req.ReceiverID,
-			Name:       req.ReceiverName,
-			IsOnline:   2,
-			Status:     1,
-			UpdatedAt:  now,
-			UnReadNum:  0,
+			MsgType:    MsgTypeQuote,
+			Content:    quote.preview(),
+			Payload:    quote.payload(),
+			Nickname:   req.SendName,
+			IsRead:     0,
+			Status:     MsgStatusSent,
+			CreatedAt:  now,
 		}
-		_ = db.Create(&sendSession).Error
-	}
-
-	// --- 获取或创建接收者会话 ---
-	var recvSession TalkSession
-	if err := db.Where("send_id=? AND receiver_id=?", req.ReceiverID, req.SendID).First(&recvSession).Error; err != nil {
-		recvSession = TalkSession{
-			SendID:     req.ReceiverID,
-			ReceiverID: req.SendID,
-			Name:       req.SendName,
-			IsOnline:   2,
-			Status:     1,
-			UpdatedAt:  now,
-			UnReadNum:  1,
+		if err := st.Messages.Create(ctx, msg); err != nil {
+			return err
 		}
-		_ = db.Create(&recvSession).Error
-	} else {
-		// 已存在会话且接收者离线，增加未读
-		clientsMu.Lock()
-		rcReceiver := clientsByUserID[req.ReceiverID]
-		clientsMu.Unlock()
-		if rcReceiver == nil {
-			db.Model(&recvSession).Update("un_read_num", gorm.Expr("un_read_num + 1"))
+		quote.MessageID = msg.ID
+		if err := st.Quotes.SetMessageID(ctx, quote.ID, msg.ID); err != nil {
+			return err
 		}
-	}
-
-	// --- 创建消息 ---
-	msg := &TalkMessage{
-		SendID:     req.SendID,
-		ReceiverID: req.ReceiverID,
-		MsgType:    1000,
-		Content:    "[复核报价]",
-		Nickname:   req.SendName,
-		IsRead:     0,
-		CreatedAt:  now,
-	}
-	_ = db.Create(msg).Error
-
-	// --- WS 推送 ---
-	clientsMu.Lock()
-	rcReceiver := clientsByUserID[req.ReceiverID]
-	rcSender := clientsByUserID[req.SendID]
-	clientsMu.Unlock()
-
-	// --- 如果接收者在线，则发送消息 ---
-	if rcReceiver != nil {
-		msg.IsRead = 1
-		_ = db.Model(msg).Update("is_read", 1)
-
-		push := map[string]any{
-			"event": "im.message",
-			"sid":   sendSession.ID,
-			"content": map[string]any{
-				"data": map[string]any{
-					"id":          msg.ID,
-					"session_id":  sendSession.ID,
-					"send_id":     req.SendID,
-					"receiver_id": req.ReceiverID,
-					"nickname":    req.SendName,
-					"avatar":      "",
-					"msg_type":    1000,
-					"content":     msg.Content,
-					"created_at":  msg.CreatedAt.Format("2006-01-02 15:04:05"),
-					"is_read":     1,
-				},
-				"receiver_id": req.ReceiverID,
-				"send_id":     req.SendID,
-			},
+		if err := st.Quotes.AddAction(ctx, &QuoteAction{
+			QuoteID: quote.ID, ActorID: req.SendID, Action: "create", ToState: QuoteStatePending,
+		}); err != nil {
+			return err
+		}
+		seqs, err := st.Messages.AppendTimeline(ctx, msg, []int{req.SendID, req.ReceiverID})
+		if err != nil {
+			return err
 		}
-		sendWS(rcReceiver, push)
-	}
-
-	// --- 更新会话最后消息 ---
-	update := map[string]any{
-		"msg_text":   msg.Content,
-		"updated_at": now,
-	}
-	db.Model(&sendSession).Updates(update)
-	db.Model(&recvSession).Updates(update)
 
-	// --- 推送最新会话状态 ---
-	var freshSend, freshRecv TalkSession
-	db.First(&freshSend, "id=?", sendSession.ID)
-	db.First(&freshRecv, "id=?", recvSession.ID)
+		// --- 更新会话最后消息 & 接收方一侧的未读（接收方显式已读时清零） ---
+		if err := st.Sessions.UpdateLastMessage(ctx, sess.ID, msg.Content, 0); err != nil {
+			return err
+		}
+		if err := st.Sessions.IncrUnread(ctx, sess.ID, req.ReceiverID); err != nil {
+			return err
+		}
+		fresh, err := st.Sessions.Get(ctx, sess.ID)
+		if err != nil {
+			return err
+		}
 
-	clientsMu.Lock()
-	if rcSender != nil {
-		freshSend.IsOnline = 1
-		sendWS(rcSender, map[string]any{"event": "session_updated", "data": freshSend})
-	}
-	if rcReceiver != nil {
-		freshRecv.IsOnline = 1
-		sendWS(rcReceiver, map[string]any{"event": "session_updated", "data": freshRecv})
+		// --- WS 推送：接收方收消息，双方收最新会话状态（离线用户投递时忽略） ---
+		var batch outboxBatch
+		batch.message(req.ReceiverID, msg, seqs)
+		fresh.IsOnline = 1
+		batch.push(req.SendID, sessionUpdatedFrame(fresh.viewedBy(req.SendID)))
+		batch.push(req.ReceiverID, sessionUpdatedFrame(fresh.viewedBy(req.ReceiverID)))
+		return batch.save(ctx, st)
+	})
+	if err != nil {
+		g.Log().Warningf(ctx, "review failed: %v", err)
+		r.Response.WriteJsonExit(g.Map{"code": 500, "msg": "操作失败"})
+		return
 	}
-	clientsMu.Unlock()
+	outbox.kick()
 
-	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "复核报价操作成功"})
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "复核报价操作成功", "data": g.Map{
+		"quote_id":   quote.ID,
+		"message_id": msg.ID,
+		"quote":      quote,
+	}})
 }
 
 func markSessionReadHandler(r *ghttp.Request) {
@@ -894,58 +737,127 @@ func markSessionReadHandler(r *ghttp.Request) {
 		SessionID int `json:"session_id"`
 		UserID    int `json:"user_id"`
 	}
-	if err := r.Parse(&req); err != nil || req.SessionID == 0 || req.UserID == 0 {
+	if err := r.Parse(&req); err != nil {
 		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
 		return
 	}
-	_ = db.Model(&TalkMessage{}).
-		Where("sid=? AND receiver_id=? AND is_read=0", req.SessionID, req.UserID).
-		Update("is_read", 1).Error
-
-	_ = db.Model(&TalkSession{}).
-		Where("id=? AND receiver_id=?", req.SessionID, req.UserID).
-		Update("un_read_num", 0).Error
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if bizErr := markSessionRead(r.Context(), req.SessionID, req.UserID); bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
 
 	// 回推最新会话列表
-	//pushSessionListTo(req.UserID)
+	//pushSessionListTo(r.Context(), req.UserID)
 	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "ok"})
 }
 
-// ---------------------- Main ----------------------
-func main() {
-	ctx := gctx.New()
-	initDB(ctx)
-
-	s := g.Server()
-
+// ---------------------- 路由 ----------------------
+// registerRoutes 注册全部路由；测试中可配合内存存储直接挂到 httptest 上
+func registerRoutes(s *ghttp.Server) {
 	// WebSocket
 	s.BindHandler("/ws", wsHandler)
+	s.BindHandler("GET:/metrics/ws", wsMetricsHandler)
+
+	// 令牌签发（上游登录服务调用）
+	s.BindHandler("POST:/auth/token", issueTokenHandler)
 
 	// REST
 	s.Group("/talk", func(group *ghttp.RouterGroup) {
+		group.Middleware(authMiddleware)
 		group.POST("/review", reviewHandler)
+		group.Group("/quote", func(gp *ghttp.RouterGroup) {
+			gp.POST("/action", quoteActionHandler)
+			gp.POST("/detail", quoteDetailHandler)
+		})
 		s.BindHandler("/user/list", userListHandler)
 		group.Group("/session", func(gp *ghttp.RouterGroup) {
 			gp.POST("/save", createSessionHandler)
 			gp.POST("/list", sessionListHandler)
+			gp.POST("/group", createGroupHandler)
+			gp.POST("/add", addMemberHandler)
+			gp.POST("/leave", leaveGroupHandler)
+			gp.POST("/kick", kickMemberHandler)
+			gp.POST("/role", setMemberRoleHandler)
+			gp.POST("/members", groupMembersHandler)
 		})
 		group.Group("/message", func(gp *ghttp.RouterGroup) {
 			gp.POST("/list", messageListHandler)
 			gp.POST("/send", sendMessageHandler)
 			gp.POST("/read", markSessionReadHandler)
+			gp.POST("/sync", messageSyncHandler)
+			gp.POST("/recall", recallMessageHandler)
+			gp.POST("/edit", editMessageHandler)
+			gp.POST("/edits", messageEditsHandler)
+			gp.POST("/delete", deleteMessagesHandler)
+			gp.POST("/thread", messageThreadHandler)
+			gp.POST("/reaction/add", addReactionHandler)
+			gp.POST("/reaction/remove", removeReactionHandler)
+			gp.POST("/search", searchMessagesHandler)
+			gp.GET("/types", messageTypesHandler)
 		})
 	})
 
 	// 上传
-	s.BindHandler("/upload/file", uploadHandler)
+	s.Group("/upload", func(group *ghttp.RouterGroup) {
+		group.Middleware(authMiddleware)
+		group.ALL("/file", uploadHandler)
+		group.POST("/init", uploadInitHandler)
+		group.PUT("/chunk", uploadChunkHandler)
+		group.GET("/status", uploadStatusHandler)
+		group.POST("/complete", uploadCompleteHandler)
+	})
+
+	// 对象存储下载（本地存储时校验签名；旧消息的 /uploads 仍走静态目录）
+	s.BindHandler("GET:/files/*key", fileDownloadHandler)
 
 	// 静态资源
 	s.SetServerRoot("static")
+}
+
+// ---------------------- Main ----------------------
+func main() {
+	ctx := gctx.New()
 
-	// 端口
 	var port string
 	flag.StringVar(&port, "port", "", "server port")
 	flag.Parse()
+
+	initDB(ctx)
+
+	// 子命令：维护类任务执行完即退出
+	switch flag.Arg(0) {
+	case "migrate":
+		if err := runMigrate(ctx, flag.Args()[1:]); err != nil {
+			g.Log().Fatalf(ctx, "migrate failed: %v", err)
+		}
+		return
+	case "backfill-sid":
+		if err := backfillSessionIDs(ctx); err != nil {
+			g.Log().Fatalf(ctx, "backfill sid failed: %v", err)
+		}
+		return
+	}
+	// 表结构由 migrate 子命令显式执行，这里只校验版本
+	ensureMigrated(ctx)
+
+	initAuth(ctx)
+	initUpgrader(ctx)
+	initOutbound(ctx)
+	initCluster(ctx)
+	initOutbox(ctx)
+	initMessageOps(ctx)
+	initTyping(ctx)
+	initPresence(ctx)
+	initSearch(ctx)
+	initQuote(ctx)
+	initStorage(ctx)
+	initUpload(ctx)
+
+	s := g.Server()
+	registerRoutes(s)
+
+	// 端口
 	if port == "" {
 		port = g.Cfg().MustGet(ctx, "server.address").String() // 如 ":8000"
 	}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gorilla/websocket"
+)
+
+// ---------------------- 测试环境 ----------------------
+// HTTP 与 WebSocket 走真实路由（registerRoutes），存储为 newMemoryStores，鉴权为 jwt。
+
+const testServiceKey = "test-service-key-0123456789abcdef"
+
+var (
+	testServerOnce sync.Once
+	testServerURL  string
+)
+
+// testServer 路由只能在 Server 启动时注册，整个包共用一个实例，由 httptest 提供监听
+func testServer(t *testing.T) string {
+	t.Helper()
+	testServerOnce.Do(func() {
+		s := g.Server("im-test")
+		registerRoutes(s)
+		s.SetAddr("127.0.0.1:0")
+		s.SetDumpRouterMap(false)
+		if err := s.Start(); err != nil {
+			t.Fatalf("start server: %v", err)
+		}
+		testServerURL = httptest.NewServer(s).URL
+	})
+	return testServerURL
+}
+
+// newTestEnv 每个测试一份全新的内存存储，并启动 outbox 投递
+func newTestEnv(t *testing.T) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	store = newMemoryStores()
+	searchIndex = newMemorySearchIndex()
+	authenticator = &jwtAuthenticator{secret: []byte("test-secret-0123456789abcdef0123456789"), issuer: "test"}
+	authServiceKey = testServiceKey
+	authTokenTTL = time.Hour
+	authMaxTTL = 24 * time.Hour
+	initUpgrader(ctx)
+	done := make(chan struct{})
+	go func() {
+		outbox.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		// 等上一个测试的投递协程退出，再由下一个测试替换全局 store
+		cancel()
+		<-done
+		authenticator = nil
+		authServiceKey = ""
+	})
+	return testServer(t)
+}
+
+type apiResult struct {
+	Code    int             `json:"code"`
+	Msg     string          `json:"msg"`
+	Data    json.RawMessage `json:"data"`
+	HasMore bool            `json:"has_more"`
+}
+
+// post 以 token 身份调用接口，token 为空时不带 Authorization
+func post(t *testing.T, base, token, path string, body any) apiResult {
+	t.Helper()
+	raw, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPost, base+path, bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	var res apiResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatalf("POST %s: decode: %v", path, err)
+	}
+	return res
+}
+
+// issueToken 通过 POST /auth/token 签发令牌
+func issueToken(t *testing.T, base string, uid int) string {
+	t.Helper()
+	raw, _ := json.Marshal(map[string]any{"user_id": uid, "name": "u"})
+	req, _ := http.NewRequest(http.MethodPost, base+"/auth/token", bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Service-Key", testServiceKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Code int `json:"code"`
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil || res.Code != 0 {
+		t.Fatalf("issue token: code=%d err=%v", res.Code, err)
+	}
+	return res.Data.Token
+}
+
+func decode[T any](t *testing.T, raw json.RawMessage) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		t.Fatalf("decode %s: %v", raw, err)
+	}
+	return v
+}
+
+// ---------------------- WebSocket 客户端 ----------------------
+
+type wsConn struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dialWS(t *testing.T, base, token string) *wsConn {
+	t.Helper()
+	u := "ws" + strings.TrimPrefix(base, "http") + "/ws?token=" + token
+	conn, resp, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("dial ws: %v (status %d)", err, status)
+	}
+	c := &wsConn{t: t, conn: conn}
+	t.Cleanup(func() { _ = conn.Close() })
+	// 首次 ping 返回 connect，确认已完成注册
+	c.send("ping", "", nil)
+	c.expect("connect")
+	return c
+}
+
+func (c *wsConn) send(event, reqID string, data any) {
+	c.t.Helper()
+	raw, _ := json.Marshal(data)
+	if err := c.conn.WriteJSON(wsFrame{Event: event, ReqID: reqID, Data: raw}); err != nil {
+		c.t.Fatalf("ws send %s: %v", event, err)
+	}
+}
+
+// expect 读到指定事件为止（跳过其他帧），超时失败
+func (c *wsConn) expect(event string) map[string]json.RawMessage {
+	c.t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		_ = c.conn.SetReadDeadline(deadline)
+		var frame map[string]json.RawMessage
+		if err := c.conn.ReadJSON(&frame); err != nil {
+			c.t.Fatalf("waiting for %s: %v", event, err)
+		}
+		var got string
+		_ = json.Unmarshal(frame["event"], &got)
+		if got == event {
+			return frame
+		}
+	}
+}
+
+// until 读到 event 为止，期间出现 unwanted 事件则失败
+func (c *wsConn) until(event, unwanted string) {
+	c.t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		_ = c.conn.SetReadDeadline(deadline)
+		var frame map[string]json.RawMessage
+		if err := c.conn.ReadJSON(&frame); err != nil {
+			c.t.Fatalf("waiting for %s: %v", event, err)
+		}
+		var got string
+		_ = json.Unmarshal(frame["event"], &got)
+		switch got {
+		case event:
+			return
+		case unwanted:
+			c.t.Fatalf("unexpected %s frame: %s", unwanted, frame["data"])
+		}
+	}
+}
+
+// ---------------------- 测试 ----------------------
+
+func TestAuthRequired(t *testing.T) {
+	base := newTestEnv(t)
+	if res := post(t, base, "", "/talk/session/list", nil); res.Code != 401 {
+		t.Fatalf("no token: code=%d, want 401", res.Code)
+	}
+	if res := post(t, base, "bad-token", "/talk/session/list", nil); res.Code != 401 {
+		t.Fatalf("bad token: code=%d, want 401", res.Code)
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(base, "http")+"/ws", nil); err == nil ||
+		resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("ws without token: err=%v", err)
+	}
+}
+
+func TestIssueTokenTTLLimit(t *testing.T) {
+	base := newTestEnv(t)
+	issue := func(ttl int) apiResult {
+		t.Helper()
+		raw, _ := json.Marshal(map[string]any{"user_id": 1, "ttl": ttl})
+		req, _ := http.NewRequest(http.MethodPost, base+"/auth/token", bytes.NewReader(raw))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Service-Key", testServiceKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var res apiResult
+		_ = json.NewDecoder(resp.Body).Decode(&res)
+		return res
+	}
+	if res := issue(int(authMaxTTL / time.Second)); res.Code != 0 {
+		t.Fatalf("ttl = maxTTL: %+v", res)
+	}
+	if res := issue(int(authMaxTTL/time.Second) + 1); res.Code != 400 {
+		t.Fatalf("ttl > maxTTL: %+v, want 400", res)
+	}
+	if res := issue(-1); res.Code != 400 {
+		t.Fatalf("negative ttl: %+v, want 400", res)
+	}
+}
+
+func TestSendMessageFlow(t *testing.T) {
+	base := newTestEnv(t)
+	alice, bob, eve := issueToken(t, base, 1), issueToken(t, base, 2), issueToken(t, base, 3)
+	bobWS := dialWS(t, base, bob)
+
+	res := post(t, base, alice, "/talk/session/save", map[string]any{"receiver_id": 2, "name": "bob"})
+	if res.Code != 0 {
+		t.Fatalf("create session: %+v", res)
+	}
+	sid := decode[struct {
+		Sid int `json:"sid"`
+	}](t, res.Data).Sid
+
+	// HTTP 发送，接收方 WebSocket 收到 im.message
+	res = post(t, base, alice, "/talk/message/send", map[string]any{
+		"session_id": sid, "receiver_id": 2, "msg_type": MsgTypeText, "content": "你好",
+	})
+	if res.Code != 0 {
+		t.Fatalf("send: %+v", res)
+	}
+	sent := decode[TalkMessage](t, res.Data)
+	frame := bobWS.expect("im.message")
+	push := decode[struct {
+		Data struct {
+			ID      int    `json:"id"`
+			Content string `json:"content"`
+		} `json:"data"`
+	}](t, frame["content"])
+	if push.Data.ID != sent.ID || push.Data.Content != "你好" {
+		t.Fatalf("im.message = %s", frame["content"])
+	}
+
+	// WebSocket 回复，ack 带回 req_id
+	bobWS.send("im.send", "r-1", map[string]any{
+		"session_id": sid, "receiver_id": 1, "msg_type": MsgTypeText, "content": "hi", "client_msg_id": "c-1",
+	})
+	ack := bobWS.expect("ack")
+	if code := decode[int](t, ack["code"]); code != 0 || decode[string](t, ack["req_id"]) != "r-1" {
+		t.Fatalf("ack = %v", ack)
+	}
+
+	// 会话参与者才能读历史
+	res = post(t, base, alice, "/talk/message/list", map[string]any{"sid": sid})
+	if res.Code != 0 {
+		t.Fatalf("list: %+v", res)
+	}
+	if msgs := decode[[]TalkMessage](t, res.Data); len(msgs) != 2 {
+		t.Fatalf("list returned %d messages, want 2", len(msgs))
+	}
+	if res = post(t, base, eve, "/talk/message/list", map[string]any{"sid": sid}); res.Code != 403 {
+		t.Fatalf("outsider list: code=%d, want 403", res.Code)
+	}
+}
+
+func TestOneToOneUnreadPerSide(t *testing.T) {
+	base := newTestEnv(t)
+	alice, bob := issueToken(t, base, 1), issueToken(t, base, 2)
+	res := post(t, base, alice, "/talk/session/save", map[string]any{"receiver_id": 2})
+	sid := decode[struct {
+		Sid int `json:"sid"`
+	}](t, res.Data).Sid
+
+	send := func(token string, receiver int) {
+		t.Helper()
+		if res := post(t, base, token, "/talk/message/send", map[string]any{
+			"session_id": sid, "receiver_id": receiver, "msg_type": MsgTypeText, "content": "x",
+		}); res.Code != 0 {
+			t.Fatalf("send: %+v", res)
+		}
+	}
+	unread := func(token string) int {
+		t.Helper()
+		res := post(t, base, token, "/talk/session/list", nil)
+		list := decode[[]TalkSession](t, res.Data)
+		if len(list) != 1 {
+			t.Fatalf("session list = %s", res.Data)
+		}
+		return list[0].UnReadNum
+	}
+
+	send(alice, 2)
+	send(alice, 2)
+	send(bob, 1)
+	if a, b := unread(alice), unread(bob); a != 1 || b != 2 {
+		t.Fatalf("unread alice=%d bob=%d, want 1 and 2", a, b)
+	}
+	if res := post(t, base, alice, "/talk/message/read", map[string]any{"session_id": sid}); res.Code != 0 {
+		t.Fatalf("read: %+v", res)
+	}
+	if a, b := unread(alice), unread(bob); a != 0 || b != 2 {
+		t.Fatalf("after alice read: alice=%d bob=%d, want 0 and 2", a, b)
+	}
+}
+
+func TestGroupMembership(t *testing.T) {
+	base := newTestEnv(t)
+	owner, member, outsider := issueToken(t, base, 1), issueToken(t, base, 2), issueToken(t, base, 3)
+	res := post(t, base, owner, "/talk/session/group", map[string]any{"name": "g", "member_ids": []int{2}})
+	if res.Code != 0 {
+		t.Fatalf("create group: %+v", res)
+	}
+	sid := decode[struct {
+		Sid int `json:"sid"`
+	}](t, res.Data).Sid
+
+	if res = post(t, base, outsider, "/talk/session/members", map[string]any{"sid": sid}); res.Code != 403 {
+		t.Fatalf("outsider members: code=%d, want 403", res.Code)
+	}
+	// 普通成员不能拉人，群主可以
+	if res = post(t, base, member, "/talk/session/add", map[string]any{"sid": sid, "user_id": 3}); res.Code != 403 {
+		t.Fatalf("member add: code=%d, want 403", res.Code)
+	}
+	if res = post(t, base, owner, "/talk/session/add", map[string]any{"sid": sid, "user_id": 3}); res.Code != 0 {
+		t.Fatalf("owner add: %+v", res)
+	}
+	res = post(t, base, outsider, "/talk/session/members", map[string]any{"sid": sid})
+	if res.Code != 0 {
+		t.Fatalf("members after add: %+v", res)
+	}
+	if list := decode[[]SessionMember](t, res.Data); len(list) != 3 {
+		t.Fatalf("members = %d, want 3", len(list))
+	}
+}
+
+func TestTypingAfterKick(t *testing.T) {
+	base := newTestEnv(t)
+	owner, member, kicked := issueToken(t, base, 1), issueToken(t, base, 2), issueToken(t, base, 3)
+	res := post(t, base, owner, "/talk/session/group", map[string]any{"name": "g", "member_ids": []int{2, 3}})
+	sid := decode[struct {
+		Sid int `json:"sid"`
+	}](t, res.Data).Sid
+	ownerWS, memberWS, kickedWS := dialWS(t, base, owner), dialWS(t, base, member), dialWS(t, base, kicked)
+
+	// start 载入成员缓存
+	ownerWS.send("typing.start", "", map[string]any{"session_id": sid})
+	memberWS.expect("typing")
+	kickedWS.expect("typing")
+
+	if res = post(t, base, owner, "/talk/session/kick", map[string]any{"sid": sid, "user_id": 3}); res.Code != 0 {
+		t.Fatalf("kick: %+v", res)
+	}
+	// 踢人后缓存失效，stop 不再转发给被踢成员
+	ownerWS.send("typing.stop", "", map[string]any{"session_id": sid})
+	memberWS.expect("typing")
+	kickedWS.send("ping", "", nil)
+	kickedWS.until("pong", "typing")
+}
+
+func TestPresenceFrame(t *testing.T) {
+	base := newTestEnv(t)
+	watcher, user := issueToken(t, base, 41), issueToken(t, base, 42)
+	watcherWS := dialWS(t, base, watcher)
+	watcherWS.send("presence.subscribe", "s1", map[string]any{"user_ids": []int{42}})
+
+	next := func() PresenceInfo {
+		t.Helper()
+		return decode[PresenceInfo](t, watcherWS.expect("user_presence")["data"])
+	}
+	userWS := dialWS(t, base, user)
+	if p := next(); p.UserID != 42 || p.Status != PresenceOnline || !p.Online || p.LastSeenAt == nil {
+		t.Fatalf("connected presence = %+v", p)
+	}
+	// away 仍算在线，online 布尔值保留
+	userWS.send("presence.set", "", map[string]any{"status": PresenceAway})
+	if p := next(); p.Status != PresenceAway || !p.Online {
+		t.Fatalf("away presence = %+v", p)
+	}
+	_ = userWS.conn.Close()
+	if p := next(); p.Status != PresenceOffline || p.Online {
+		t.Fatalf("offline presence = %+v", p)
+	}
+}
+
+func TestRecalledQuoteCard(t *testing.T) {
+	base := newTestEnv(t)
+	sender, receiver := issueToken(t, base, 1), issueToken(t, base, 2)
+	review := func() (quoteID, messageID int) {
+		t.Helper()
+		res := post(t, base, sender, "/talk/review", map[string]any{
+			"receiver_id": 2, "items": []map[string]any{{"name": "安装费", "quantity": 1, "unit_price": 50000}},
+		})
+		if res.Code != 0 {
+			t.Fatalf("review: %+v", res)
+		}
+		d := decode[struct {
+			QuoteID   int `json:"quote_id"`
+			MessageID int `json:"message_id"`
+		}](t, res.Data)
+		return d.QuoteID, d.MessageID
+	}
+
+	// 撤回时报价在同一事务中失效，之后不能再操作
+	quoteID, messageID := review()
+	if res := post(t, base, sender, "/talk/message/recall", map[string]any{"message_id": messageID}); res.Code != 0 {
+		t.Fatalf("recall: %+v", res)
+	}
+	if q, _ := store.Quotes.Get(context.Background(), quoteID); q.State != QuoteStateExpired {
+		t.Fatalf("quote state after recall = %s, want expired", q.State)
+	}
+	if res := post(t, base, receiver, "/talk/quote/action", map[string]any{"quote_id": quoteID, "action": "accept"}); res.Code != 400 {
+		t.Fatalf("accept recalled quote: %+v", res)
+	}
+
+	// 撤回前已是 pending 的旧数据：同样拒绝
+	quoteID, messageID = review()
+	if err := store.Messages.Recall(context.Background(), messageID); err != nil {
+		t.Fatal(err)
+	}
+	if res := post(t, base, receiver, "/talk/quote/action", map[string]any{"quote_id": quoteID, "action": "accept"}); res.Code != 400 || res.Msg != "报价卡片已撤回" {
+		t.Fatalf("accept legacy recalled quote: %+v", res)
+	}
+	if q, _ := store.Quotes.Get(context.Background(), quoteID); q.State != QuoteStatePending {
+		t.Fatalf("quote state = %s, want pending", q.State)
+	}
+}
+
+func TestReviewReceiverSide(t *testing.T) {
+	base := newTestEnv(t)
+	sender, receiver := issueToken(t, base, 1), issueToken(t, base, 2)
+	// 接收方先建了反方向的会话：review 复用它，不再另建镜像会话
+	res := post(t, base, receiver, "/talk/session/save", map[string]any{"receiver_id": 1})
+	sid := decode[struct {
+		Sid int `json:"sid"`
+	}](t, res.Data).Sid
+
+	review := func() int {
+		t.Helper()
+		res := post(t, base, sender, "/talk/review", map[string]any{"receiver_id": 2, "receiver_name": "B"})
+		if res.Code != 0 {
+			t.Fatalf("review: %+v", res)
+		}
+		return decode[struct {
+			MessageID int `json:"message_id"`
+		}](t, res.Data).MessageID
+	}
+	sessions := func(token string) []TalkSession {
+		t.Helper()
+		list := decode[[]TalkSession](t, post(t, base, token, "/talk/session/list", nil).Data)
+		if len(list) != 1 || list[0].ID != sid {
+			t.Fatalf("session list = %+v, want only sid %d", list, sid)
+		}
+		return list
+	}
+
+	first, second := review(), review()
+	for _, id := range []int{first, second} {
+		if msg, _ := store.Messages.Get(context.Background(), id); msg.Sid != sid {
+			t.Fatalf("card %d stored under sid %d, want %d", id, msg.Sid, sid)
+		}
+	}
+	if s := sessions(receiver)[0]; s.UnReadNum != 2 || s.MsgText == "" {
+		t.Fatalf("receiver session = %+v, want 2 unread", s)
+	}
+	if s := sessions(sender)[0]; s.UnReadNum != 0 {
+		t.Fatalf("sender session = %+v, want 0 unread", s)
+	}
+
+	if res := post(t, base, receiver, "/talk/message/read", map[string]any{"session_id": sid}); res.Code != 0 {
+		t.Fatalf("read: %+v", res)
+	}
+	if s := sessions(receiver)[0]; s.UnReadNum != 0 {
+		t.Fatalf("receiver unread after read = %d", s.UnReadNum)
+	}
+	for _, id := range []int{first, second} {
+		if msg, _ := store.Messages.Get(context.Background(), id); msg.Status != MsgStatusRead {
+			t.Fatalf("card %d status = %d, want read", id, msg.Status)
+		}
+	}
+}
+
+// legacyMessage 未回填 sid 的历史消息
+func legacyMessage(t *testing.T, sendID, receiverID int) *TalkMessage {
+	t.Helper()
+	msg := &TalkMessage{SendID: sendID, ReceiverID: receiverID, MsgType: MsgTypeText, Content: "old", CreatedAt: time.Now()}
+	if err := store.Messages.Create(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestReactionOnLegacyMessage(t *testing.T) {
+	base := newTestEnv(t)
+	receiver := issueToken(t, base, 2)
+	msg := legacyMessage(t, 1, 2)
+	ws := dialWS(t, base, receiver)
+	if res := post(t, base, receiver, "/talk/message/reaction/add", map[string]any{"message_id": msg.ID, "emoji": "👍"}); res.Code != 0 {
+		t.Fatalf("react: %+v", res)
+	}
+	ws.expect("im.reaction")
+}
+
+func TestEditAndRecallLegacyMessage(t *testing.T) {
+	base := newTestEnv(t)
+	sender, receiver := issueToken(t, base, 1), issueToken(t, base, 2)
+	msg := legacyMessage(t, 1, 2)
+	ws := dialWS(t, base, receiver)
+
+	if res := post(t, base, sender, "/talk/message/edit", map[string]any{"message_id": msg.ID, "content": "new"}); res.Code != 0 {
+		t.Fatalf("edit: %+v", res)
+	}
+	ws.expect("im.edit")
+	if res := post(t, base, sender, "/talk/message/recall", map[string]any{"message_id": msg.ID}); res.Code != 0 {
+		t.Fatalf("recall: %+v", res)
+	}
+	ws.expect("im.recall")
+}
+
+func TestReplyProbeByOutsider(t *testing.T) {
+	base := newTestEnv(t)
+	alice, outsider := issueToken(t, base, 1), issueToken(t, base, 3)
+	sid := decode[struct {
+		Sid int `json:"sid"`
+	}](t, post(t, base, alice, "/talk/session/save", map[string]any{"receiver_id": 2}).Data).Sid
+	res := post(t, base, alice, "/talk/message/send", map[string]any{
+		"session_id": sid, "receiver_id": 2, "msg_type": MsgTypeText, "content": "x",
+	})
+	msgID := decode[struct {
+		ID int `json:"id"`
+	}](t, res.Data).ID
+	if msgID == 0 {
+		t.Fatalf("send: %+v", res)
+	}
+	group := decode[struct {
+		Sid int `json:"sid"`
+	}](t, post(t, base, alice, "/talk/session/group", map[string]any{"name": "g", "member_ids": []int{2}}).Data).Sid
+
+	// 引用的消息在不在该会话，非参与者得到的都是 403
+	for _, target := range []int{sid, group} {
+		for _, replyTo := range []int{msgID, msgID + 100} {
+			res := post(t, base, outsider, "/talk/message/send", map[string]any{
+				"session_id": target, "receiver_id": 1, "msg_type": MsgTypeText, "content": "?", "reply_to_id": replyTo,
+			})
+			if res.Code != 403 {
+				t.Fatalf("sid=%d reply_to=%d: %+v, want 403", target, replyTo, res)
+			}
+		}
+	}
+}
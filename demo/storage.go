@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// ---------------------- 对象存储 ----------------------
+// 上传的文件（含分片）统一写入对象存储，消息记录对象 key（message.object_key），
+// payload.url 落库为不带签名的 /files/<key>，读取/推送时替换为带有效期的下载地址。
+// storage.driver：
+//   - local 本地磁盘（storage.local.root），下载地址由本服务 GET /files/<key> 校验签名后提供；
+//     多节点部署时 root 需为共享目录
+//   - s3    S3 兼容存储（AWS S3 / MinIO），下载地址为预签名 URL，直接访问存储
+// 旧消息的 /uploads/... 地址仍由静态目录提供。
+
+type Storage interface {
+	// Put 写入对象，size 为 r 的字节数
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open 读取对象，不存在返回 ErrNotFound
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete 删除对象，不存在时不报错
+	Delete(ctx context.Context, key string) error
+	// SignedURL 生成 expire 内有效的下载地址
+	SignedURL(key string, expire time.Duration) (string, error)
+}
+
+var (
+	objectStorage Storage = &localStorage{root: "data/objects", secret: randomSecret()}
+	urlExpire             = time.Hour
+)
+
+func initStorage(ctx context.Context) {
+	urlExpire = time.Duration(g.Cfg().MustGet(ctx, "storage.urlExpire", 3600).Int()) * time.Second
+	switch driver := g.Cfg().MustGet(ctx, "storage.driver", "local").String(); driver {
+	case "local":
+		// 随机密钥重启后旧地址失效、多节点间互不认可，因此必须显式配置
+		secret := g.Cfg().MustGet(ctx, "storage.local.secret").String()
+		if v := os.Getenv("STORAGE_SECRET"); v != "" {
+			secret = v
+		}
+		if secret == "" {
+			panic("missing storage secret: set config \"storage.local.secret\" or env STORAGE_SECRET")
+		}
+		if isPlaceholderSecret(secret) || len(secret) < authMinSecretLen {
+			panic(fmt.Sprintf("storage.local.secret is too weak: use a random string of at least %d bytes", authMinSecretLen))
+		}
+		objectStorage = &localStorage{
+			root:   g.Cfg().MustGet(ctx, "storage.local.root", "data/objects").String(),
+			secret: secret,
+		}
+	case "s3":
+		s3, err := newS3Storage(
+			g.Cfg().MustGet(ctx, "storage.s3.endpoint").String(),
+			g.Cfg().MustGet(ctx, "storage.s3.region", "us-east-1").String(),
+			g.Cfg().MustGet(ctx, "storage.s3.bucket").String(),
+			g.Cfg().MustGet(ctx, "storage.s3.accessKey").String(),
+			g.Cfg().MustGet(ctx, "storage.s3.secretKey").String(),
+			g.Cfg().MustGet(ctx, "storage.s3.pathStyle", true).Bool(),
+		)
+		if err != nil {
+			panic("storage.s3: " + err.Error())
+		}
+		objectStorage = s3
+	default:
+		panic("unknown storage driver: " + driver)
+	}
+	g.Log().Infof(ctx, "object storage: %T", objectStorage)
+}
+
+func randomSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// objectKey 新上传文件的 key：<类型名>/<日期>/<id><扩展名>
+func objectKey(msgType int, id, name string) string {
+	prefix := "misc"
+	if t := messageTypeOf(msgType); t != nil {
+		prefix = t.Name
+	}
+	ext, _ := objectExt(name)
+	return fmt.Sprintf("%s/%s/%s%s", prefix, time.Now().Format("20060102"), id, ext)
+}
+
+// objectExt 文件名的扩展名（小写，含点），只允许 1~16 位字母数字；没有扩展名返回 "", true
+func objectExt(name string) (string, bool) {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return "", true
+	}
+	if len(ext) > 17 {
+		return "", false
+	}
+	for _, c := range ext[1:] {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return "", false
+		}
+	}
+	return ext, len(ext) > 1
+}
+
+// validObjectKey key 只允许相对路径，不能包含 .. 或空段
+func validObjectKey(key string) bool {
+	if key == "" || len(key) > 255 || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// objectPath 落库的 payload.url，不带签名
+func objectPath(key string) string {
+	return "/files/" + key
+}
+
+// signMessageURL 有对象 key 的消息把 payload.url 换成带有效期的下载地址
+func signMessageURL(m *TalkMessage) {
+	if m.ObjectKey == "" || len(m.Payload) == 0 || m.IsRecalled == 1 {
+		return
+	}
+	var p map[string]any
+	if err := json.Unmarshal(m.Payload, &p); err != nil || p == nil {
+		return
+	}
+	signed, err := objectStorage.SignedURL(m.ObjectKey, urlExpire)
+	if err != nil {
+		return
+	}
+	p["url"] = signed
+	if data, err := json.Marshal(p); err == nil {
+		m.Payload = data
+	}
+}
+
+// signPushFrame 投递前为 im.message 帧里的 content.data.payload.url 签名；outbox 中只保存未签名地址，
+// 补投或积压后投递的帧不会带着已过期的链接
+func signPushFrame(frame json.RawMessage, key string) json.RawMessage {
+	dec := json.NewDecoder(bytes.NewReader(frame))
+	dec.UseNumber()
+	var f map[string]any
+	if err := dec.Decode(&f); err != nil {
+		return frame
+	}
+	content, _ := f["content"].(map[string]any)
+	data, _ := content["data"].(map[string]any)
+	payload, _ := data["payload"].(map[string]any)
+	if payload == nil {
+		return frame
+	}
+	signed, err := objectStorage.SignedURL(key, urlExpire)
+	if err != nil {
+		return frame
+	}
+	payload["url"] = signed
+	out, err := json.Marshal(f)
+	if err != nil {
+		return frame
+	}
+	return out
+}
+
+// signMessageURLs 为消息列表批量生成下载地址
+func signMessageURLs(msgs []TalkMessage) {
+	for i := range msgs {
+		signMessageURL(&msgs[i])
+	}
+}
+
+// fileURLOf 消息文件的下载地址（对象存储中的文件为签名地址）
+func fileURLOf(m *TalkMessage) string {
+	signMessageURL(m)
+	var p map[string]any
+	_ = json.Unmarshal(m.Payload, &p)
+	return payloadString(p, "url")
+}
+
+// ---------------------- 本地磁盘 ----------------------
+
+type localStorage struct {
+	root   string
+	secret string // 下载地址签名密钥，多节点需一致
+}
+
+func (s *localStorage) path(key string) (string, error) {
+	if !validObjectKey(key) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *localStorage) Put(_ context.Context, key string, r io.Reader, size int64, _ string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// 先写临时文件再改名，中断时不会留下不完整的对象
+	f, err := os.CreateTemp(filepath.Dir(p), ".put-*")
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n != size {
+		err = fmt.Errorf("short write: %d/%d", n, size)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func (s *localStorage) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *localStorage) Delete(_ context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStorage) sign(key string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(s.secret))
+	mac.Write([]byte(key + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *localStorage) SignedURL(key string, expire time.Duration) (string, error) {
+	if !validObjectKey(key) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	expires := time.Now().Add(expire).Unix()
+	u := url.URL{Path: objectPath(key)}
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sig", s.sign(key, expires))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// verify 校验下载地址的签名与有效期
+func (s *localStorage) verify(key string, expires int64, sig string) bool {
+	if time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(s.sign(key, expires)))
+}
+
+// 下载文件（本地存储）
+// GET /files/<key>?expires=1700000000&sig=xxx
+func fileDownloadHandler(r *ghttp.Request) {
+	local, ok := objectStorage.(*localStorage)
+	if !ok {
+		r.Response.WriteStatus(http.StatusNotFound)
+		return
+	}
+	key := r.Get("key").String()
+	if !validObjectKey(key) || !local.verify(key, r.GetQuery("expires").Int64(), r.GetQuery("sig").String()) {
+		r.Response.WriteStatus(http.StatusForbidden, "链接无效或已过期")
+		return
+	}
+	p, _ := local.path(key)
+	if _, err := os.Stat(p); err != nil {
+		r.Response.WriteStatus(http.StatusNotFound)
+		return
+	}
+	r.Response.ServeFile(p)
+}
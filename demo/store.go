@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ---------------------- 存储接口 ----------------------
+// 业务代码只依赖这里的接口：
+//   - newGormStores：MySQL（生产）
+//   - newMemoryStores：进程内实现，go test 中无需数据库即可跑通 HTTP + WebSocket 全流程
+
+var ErrNotFound = errors.New("record not found")
+
+// MessageQuery 会话消息游标查询
+type MessageQuery struct {
+	Sid      int
+	BeforeID int // >0 时取 id < BeforeID，id 倒序
+	AfterID  int // >0 时取 id > AfterID，id 正序（优先于 BeforeID）
+	Limit    int
+}
+
+// StatusFilter 消息状态推进的范围：按消息ID或按会话，始终限定接收者
+type StatusFilter struct {
+	IDs        []int
+	Sid        int
+	ReceiverID int
+}
+
+type MessageStore interface {
+	Create(ctx context.Context, msg *TalkMessage) error
+	Get(ctx context.Context, id int) (*TalkMessage, error)
+	GetByIDs(ctx context.Context, ids []int) ([]TalkMessage, error)
+	// FindByClientMsgID 按发送者 + 客户端消息ID 查找，不存在返回 ErrNotFound
+	FindByClientMsgID(ctx context.Context, sendID int, clientMsgID string) (*TalkMessage, error)
+	List(ctx context.Context, q MessageQuery) ([]TalkMessage, error)
+	// AdvanceStatus 把范围内状态低于 status 的消息推进到 status，返回被推进的消息（推进前的快照）
+	AdvanceStatus(ctx context.Context, f StatusFilter, status int) ([]TalkMessage, error)
+
+	// Scan 按 id 正序批量读取 id > afterID 的消息（重建搜索索引用）
+	Scan(ctx context.Context, afterID, limit int) ([]TalkMessage, error)
+	// Replies 回复 rootID 的消息中 id > afterID 的部分，按 id 正序
+	Replies(ctx context.Context, rootID, afterID, limit int) ([]TalkMessage, error)
+	// Recall 撤回：标记 is_recalled 并清空内容
+	Recall(ctx context.Context, id int) error
+	// Edit 修改内容并记录编辑前的内容
+	Edit(ctx context.Context, id, editorID int, content string, payload json.RawMessage) error
+	EditHistory(ctx context.Context, id int) ([]MessageEdit, error)
+	// SetPayload 由服务端更新卡片内容（不记编辑历史）
+	SetPayload(ctx context.Context, id int, content string, payload json.RawMessage) error
+	// Hide 仅对 uid 隐藏（删除）消息
+	Hide(ctx context.Context, uid int, ids []int) error
+	// HiddenIDs 返回 ids 中被 uid 隐藏的消息
+	HiddenIDs(ctx context.Context, uid int, ids []int) (map[int]bool, error)
+	// AddReaction 添加表情回应，已存在时返回 false
+	AddReaction(ctx context.Context, id, uid int, emoji string) (bool, error)
+	// RemoveReaction 取消表情回应，不存在时返回 false
+	RemoveReaction(ctx context.Context, id, uid int, emoji string) (bool, error)
+	// Reactions 按消息、表情聚合回应数（按首次回应时间排序），Reacted 标记 uid 是否回应过
+	Reactions(ctx context.Context, uid int, ids []int) (map[int][]ReactionCount, error)
+
+	// AppendTimeline 为每个用户分配下一个 seq 并写入时间线，返回 user_id -> seq
+	AppendTimeline(ctx context.Context, msg *TalkMessage, uids []int) (map[int]int64, error)
+	// Timeline 返回用户 seq > afterSeq 的时间线记录，按 seq 升序
+	Timeline(ctx context.Context, uid int, afterSeq int64, limit int) ([]UserTimeline, error)
+}
+
+type SessionStore interface {
+	Create(ctx context.Context, s *TalkSession) error
+	Get(ctx context.Context, id int) (*TalkSession, error)
+	// FindPair 按 send_id/receiver_id 精确查找单聊会话
+	FindPair(ctx context.Context, sendID, receiverID int) (*TalkSession, error)
+	// ListForUser 用户可见的会话（单聊双方 + 所在群），未读数为该用户自己一侧/该成员的未读数
+	ListForUser(ctx context.Context, uid int) ([]TalkSession, error)
+	// UpdateLastMessage 更新最后一条消息与时间，unreadFor 非 0 时该参与者一侧的未读 +1
+	UpdateLastMessage(ctx context.Context, id int, text string, unreadFor int) error
+	// SetLastText 只改最后一条消息的展示文本（撤回/编辑），不影响排序与未读
+	SetLastText(ctx context.Context, id int, text string) error
+	// IncrUnread 单聊会话中 uid 一侧的未读 +1
+	IncrUnread(ctx context.Context, id, uid int) error
+	// ClearUnread 清零单聊会话中 uid 一侧的未读
+	ClearUnread(ctx context.Context, id, uid int) error
+	SetStatus(ctx context.Context, id, status int) error
+
+	// 群成员
+	CreateGroup(ctx context.Context, s *TalkSession, members []SessionMember) error
+	// Member 在群成员，不在群返回 ErrNotFound
+	Member(ctx context.Context, sid, uid int) (*SessionMember, error)
+	// Members 在群成员，按角色、入群时间排序
+	Members(ctx context.Context, sid int) ([]SessionMember, error)
+	// AddMember 加入或重新加入
+	AddMember(ctx context.Context, sid, uid, role int) error
+	RemoveMember(ctx context.Context, sid, uid int) error
+	SetMemberRole(ctx context.Context, sid, uid, role int) error
+	// IncrMemberUnread 除 exceptUID 外的在群成员未读 +1
+	IncrMemberUnread(ctx context.Context, sid, exceptUID int) error
+	ClearMemberUnread(ctx context.Context, sid, uid int) error
+}
+
+type UserStore interface {
+	List(ctx context.Context) ([]TalkUser, error)
+	// Upsert 不存在则创建；存在且昵称/头像有变化则更新（空值不覆盖）
+	Upsert(ctx context.Context, uid int, name, avatar string) error
+	// GetByUserIDs 按 user_id 批量查询
+	GetByUserIDs(ctx context.Context, uids []int) ([]TalkUser, error)
+	// SetPresence 更新在线状态与最后在线时间，用户不存在时忽略
+	SetPresence(ctx context.Context, uid int, status string, at time.Time) error
+}
+
+// OutboxStore 待推送事件，与业务写入同一事务落库，由 outboxDispatcher 投递
+type OutboxStore interface {
+	Enqueue(ctx context.Context, entries []OutboxEntry) error
+	// Claim 以 token 认领最多 limit 条待投递（或认领超过 staleAfter 仍未完成）的记录
+	Claim(ctx context.Context, token string, limit int, staleAfter time.Duration) ([]OutboxEntry, error)
+	// Done 删除已投递的记录
+	Done(ctx context.Context, ids []int64) error
+}
+
+// QuoteStore 复核报价与操作审计
+type QuoteStore interface {
+	Create(ctx context.Context, q *Quote) error
+	Get(ctx context.Context, id int) (*Quote, error)
+	SetMessageID(ctx context.Context, id, messageID int) error
+	// Transition 仅当当前状态为 from 时改为 to（counter 非空时一并写入），返回是否更新
+	Transition(ctx context.Context, id int, from, to string, counter json.RawMessage) (bool, error)
+	AddAction(ctx context.Context, a *QuoteAction) error
+	// Actions 操作记录，按时间正序
+	Actions(ctx context.Context, quoteID int) ([]QuoteAction, error)
+}
+
+// UploadStore 分片上传会话
+type UploadStore interface {
+	Create(ctx context.Context, u *UploadSession) error
+	Get(ctx context.Context, id string) (*UploadSession, error)
+	// PutChunk 登记已收到的分片，同一序号重复上传时覆盖
+	PutChunk(ctx context.Context, c *UploadChunk) error
+	// Chunks 已收到的分片，按序号升序
+	Chunks(ctx context.Context, uploadID string) ([]UploadChunk, error)
+	// Transition 状态为 from 时改为 to，返回是否成功（用于并发 complete 时抢占合并）
+	Transition(ctx context.Context, id, from, to string) (bool, error)
+	// Complete 标记完成并删除分片记录
+	Complete(ctx context.Context, id string, messageID int) error
+	// Expired 过期时间早于 before 的上传会话
+	Expired(ctx context.Context, before time.Time, limit int) ([]UploadSession, error)
+	// Delete 删除上传会话及其分片记录
+	Delete(ctx context.Context, id string) error
+}
+
+// Stores 聚合所有存储，业务代码通过全局 store 访问
+type Stores struct {
+	Messages MessageStore
+	Sessions SessionStore
+	Users    UserStore
+	Outbox   OutboxStore
+	Quotes   QuoteStore
+	Uploads  UploadStore
+
+	tx func(ctx context.Context, fn func(st *Stores) error) error
+}
+
+// Tx 在同一事务内执行 fn，fn 返回错误时整体回滚；fn 内只能使用参数 st 访问存储
+func (s *Stores) Tx(ctx context.Context, fn func(st *Stores) error) error {
+	if s.tx == nil {
+		return fn(s)
+	}
+	return s.tx(ctx, fn)
+}
+
+var store *Stores
@@ -0,0 +1,625 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ---------------------- GORM 实现 ----------------------
+
+func newGormStores(conn *gorm.DB) *Stores {
+	return &Stores{
+		Messages: &gormMessageStore{db: conn},
+		Sessions: &gormSessionStore{db: conn},
+		Users:    &gormUserStore{db: conn},
+		Outbox:   &gormOutboxStore{db: conn},
+		Quotes:   &gormQuoteStore{db: conn},
+		Uploads:  &gormUploadStore{db: conn},
+		tx: func(ctx context.Context, fn func(st *Stores) error) error {
+			return conn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				return fn(newGormStores(tx))
+			})
+		},
+	}
+}
+
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ---------------------- 消息 ----------------------
+type gormMessageStore struct{ db *gorm.DB }
+
+func (s *gormMessageStore) Create(ctx context.Context, msg *TalkMessage) error {
+	return s.db.WithContext(ctx).Create(msg).Error
+}
+
+func (s *gormMessageStore) Get(ctx context.Context, id int) (*TalkMessage, error) {
+	var m TalkMessage
+	if err := s.db.WithContext(ctx).First(&m, "id=?", id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &m, nil
+}
+
+func (s *gormMessageStore) GetByIDs(ctx context.Context, ids []int) ([]TalkMessage, error) {
+	var msgs []TalkMessage
+	if len(ids) == 0 {
+		return msgs, nil
+	}
+	err := s.db.WithContext(ctx).Where("id IN ?", ids).Find(&msgs).Error
+	return msgs, err
+}
+
+func (s *gormMessageStore) FindByClientMsgID(ctx context.Context, sendID int, clientMsgID string) (*TalkMessage, error) {
+	var m TalkMessage
+	if err := s.db.WithContext(ctx).Where("send_id=? AND client_msg_id=?", sendID, clientMsgID).
+		First(&m).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &m, nil
+}
+
+func (s *gormMessageStore) List(ctx context.Context, q MessageQuery) ([]TalkMessage, error) {
+	tx := s.db.WithContext(ctx).Where("sid = ?", q.Sid)
+	switch {
+	case q.AfterID > 0:
+		tx = tx.Where("id > ?", q.AfterID).Order("id asc")
+	case q.BeforeID > 0:
+		tx = tx.Where("id < ?", q.BeforeID).Order("id desc")
+	default:
+		tx = tx.Order("id desc")
+	}
+	var msgs []TalkMessage
+	err := tx.Limit(q.Limit).Find(&msgs).Error
+	return msgs, err
+}
+
+func (s *gormMessageStore) AdvanceStatus(ctx context.Context, f StatusFilter, status int) ([]TalkMessage, error) {
+	tx := s.db.WithContext(ctx).Where("receiver_id=? AND status < ?", f.ReceiverID, status)
+	if len(f.IDs) > 0 {
+		tx = tx.Where("id IN ?", f.IDs)
+	}
+	if f.Sid > 0 {
+		tx = tx.Where("sid=?", f.Sid)
+	}
+	var msgs []TalkMessage
+	if err := tx.Find(&msgs).Error; err != nil || len(msgs) == 0 {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(msgs))
+	for _, m := range msgs {
+		ids = append(ids, m.ID)
+	}
+	update := map[string]any{"status": status}
+	if status == MsgStatusRead {
+		update["is_read"] = 1
+	}
+	if err := s.db.WithContext(ctx).Model(&TalkMessage{}).
+		Where("id IN ? AND status < ?", ids, status).Updates(update).Error; err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+func (s *gormMessageStore) Scan(ctx context.Context, afterID, limit int) ([]TalkMessage, error) {
+	var msgs []TalkMessage
+	err := s.db.WithContext(ctx).Where("id > ?", afterID).Order("id asc").Limit(limit).Find(&msgs).Error
+	return msgs, err
+}
+
+func (s *gormMessageStore) Replies(ctx context.Context, rootID, afterID, limit int) ([]TalkMessage, error) {
+	var msgs []TalkMessage
+	err := s.db.WithContext(ctx).Where("reply_to_id=? AND id>?", rootID, afterID).
+		Order("id asc").Limit(limit).Find(&msgs).Error
+	return msgs, err
+}
+
+func (s *gormMessageStore) Recall(ctx context.Context, id int) error {
+	return s.db.WithContext(ctx).Model(&TalkMessage{}).Where("id=?", id).
+		Updates(map[string]any{"is_recalled": 1, "content": "", "payload": nil}).Error
+}
+
+func (s *gormMessageStore) Edit(ctx context.Context, id, editorID int, content string, payload json.RawMessage) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var m TalkMessage
+		if err := tx.First(&m, "id=?", id).Error; err != nil {
+			return notFound(err)
+		}
+		if err := tx.Create(&MessageEdit{MessageID: id, EditorID: editorID, Content: m.Content}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&TalkMessage{}).Where("id=?", id).
+			Updates(map[string]any{"content": content, "payload": payload, "edited_at": time.Now()}).Error
+	})
+}
+
+func (s *gormMessageStore) SetPayload(ctx context.Context, id int, content string, payload json.RawMessage) error {
+	return s.db.WithContext(ctx).Model(&TalkMessage{}).Where("id=?", id).
+		Updates(map[string]any{"content": content, "payload": payload}).Error
+}
+
+func (s *gormMessageStore) EditHistory(ctx context.Context, id int) ([]MessageEdit, error) {
+	var list []MessageEdit
+	err := s.db.WithContext(ctx).Where("message_id=?", id).Order("id asc").Find(&list).Error
+	return list, err
+}
+
+func (s *gormMessageStore) Hide(ctx context.Context, uid int, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	rows := make([]MessageHidden, 0, len(ids))
+	for _, id := range ids {
+		rows = append(rows, MessageHidden{MessageID: id, UserID: uid})
+	}
+	// 已隐藏的跳过（唯一索引），并发请求不会因重复插入失败
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+func (s *gormMessageStore) HiddenIDs(ctx context.Context, uid int, ids []int) (map[int]bool, error) {
+	hidden := make(map[int]bool)
+	if uid == 0 || len(ids) == 0 {
+		return hidden, nil
+	}
+	var rows []int
+	if err := s.db.WithContext(ctx).Model(&MessageHidden{}).
+		Where("user_id=? AND message_id IN ?", uid, ids).Pluck("message_id", &rows).Error; err != nil {
+		return hidden, err
+	}
+	for _, id := range rows {
+		hidden[id] = true
+	}
+	return hidden, nil
+}
+
+func (s *gormMessageStore) AddReaction(ctx context.Context, id, uid int, emoji string) (bool, error) {
+	res := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&MessageReaction{MessageID: id, UserID: uid, Emoji: emoji})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *gormMessageStore) RemoveReaction(ctx context.Context, id, uid int, emoji string) (bool, error) {
+	res := s.db.WithContext(ctx).Where("message_id=? AND user_id=? AND emoji=?", id, uid, emoji).Delete(&MessageReaction{})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *gormMessageStore) Reactions(ctx context.Context, uid int, ids []int) (map[int][]ReactionCount, error) {
+	result := make(map[int][]ReactionCount)
+	if len(ids) == 0 {
+		return result, nil
+	}
+	var rows []struct {
+		MessageID int
+		Emoji     string
+		Cnt       int
+		Mine      int
+	}
+	err := s.db.WithContext(ctx).Model(&MessageReaction{}).
+		Select("message_id, emoji, COUNT(*) AS cnt, MAX(CASE WHEN user_id=? THEN 1 ELSE 0 END) AS mine", uid).
+		Where("message_id IN ?", ids).
+		Group("message_id, emoji").Order("MIN(id) asc").Scan(&rows).Error
+	if err != nil {
+		return result, err
+	}
+	for _, r := range rows {
+		result[r.MessageID] = append(result[r.MessageID], ReactionCount{Emoji: r.Emoji, Count: r.Cnt, Reacted: r.Mine == 1})
+	}
+	return result, nil
+}
+
+func (s *gormMessageStore) AppendTimeline(ctx context.Context, msg *TalkMessage, uids []int) (map[int]int64, error) {
+	seqs := make(map[int]int64, len(uids))
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, uid := range uids {
+			if _, ok := seqs[uid]; ok || uid == 0 {
+				continue
+			}
+			seq, err := nextSeq(tx, uid)
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(&UserTimeline{UserID: uid, Seq: seq, MessageID: msg.ID, Sid: msg.Sid}).Error; err != nil {
+				return err
+			}
+			seqs[uid] = seq
+		}
+		return nil
+	})
+	return seqs, err
+}
+
+// nextSeq 在事务内为用户分配下一个序号（UPDATE 行锁保证并发下单调）
+func nextSeq(tx *gorm.DB, uid int) (int64, error) {
+	res := tx.Model(&UserSeq{}).Where("user_id=?", uid).Update("seq", gorm.Expr("seq + 1"))
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected == 0 {
+		if err := tx.Create(&UserSeq{UserID: uid, Seq: 1}).Error; err == nil {
+			return 1, nil
+		}
+		// 并发首次创建冲突，回到自增路径
+		if err := tx.Model(&UserSeq{}).Where("user_id=?", uid).Update("seq", gorm.Expr("seq + 1")).Error; err != nil {
+			return 0, err
+		}
+	}
+	var us UserSeq
+	if err := tx.First(&us, "user_id=?", uid).Error; err != nil {
+		return 0, err
+	}
+	return us.Seq, nil
+}
+
+func (s *gormMessageStore) Timeline(ctx context.Context, uid int, afterSeq int64, limit int) ([]UserTimeline, error) {
+	var rows []UserTimeline
+	err := s.db.WithContext(ctx).Where("user_id=? AND seq>?", uid, afterSeq).
+		Order("seq asc").Limit(limit).Find(&rows).Error
+	return rows, err
+}
+
+// ---------------------- 会话 ----------------------
+type gormSessionStore struct{ db *gorm.DB }
+
+func (s *gormSessionStore) Create(ctx context.Context, sess *TalkSession) error {
+	return s.db.WithContext(ctx).Create(sess).Error
+}
+
+func (s *gormSessionStore) Get(ctx context.Context, id int) (*TalkSession, error) {
+	var sess TalkSession
+	if err := s.db.WithContext(ctx).First(&sess, "id=?", id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &sess, nil
+}
+
+func (s *gormSessionStore) FindPair(ctx context.Context, sendID, receiverID int) (*TalkSession, error) {
+	var sess TalkSession
+	if err := s.db.WithContext(ctx).Where("send_id=? AND receiver_id=?", sendID, receiverID).
+		First(&sess).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &sess, nil
+}
+
+func (s *gormSessionStore) ListForUser(ctx context.Context, uid int) ([]TalkSession, error) {
+	db := s.db.WithContext(ctx)
+	var list []TalkSession
+	if err := db.Where("status=1 AND (receiver_id=? OR send_id=? OR id IN (?))", uid, uid,
+		db.Model(&SessionMember{}).Select("sid").Where("user_id=? AND status=1", uid)).
+		Order("updated_at desc").Find(&list).Error; err != nil {
+		return nil, err
+	}
+
+	// 单聊取自己一侧的未读数，群聊替换为该成员自己的未读数
+	var sids []int
+	for i, sess := range list {
+		if sess.Type == SessionTypeGroup {
+			sids = append(sids, sess.ID)
+		} else {
+			list[i] = sess.viewedBy(uid)
+		}
+	}
+	if len(sids) == 0 {
+		return list, nil
+	}
+	var rows []SessionMember
+	if err := db.Where("user_id=? AND sid IN ?", uid, sids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	unread := make(map[int]int, len(rows))
+	for _, m := range rows {
+		unread[m.Sid] = m.UnReadNum
+	}
+	for i := range list {
+		if list[i].Type == SessionTypeGroup {
+			list[i].UnReadNum = unread[list[i].ID]
+		}
+	}
+	return list, nil
+}
+
+func (s *gormSessionStore) UpdateLastMessage(ctx context.Context, id int, text string, unreadFor int) error {
+	update := map[string]any{
+		"msg_text":   text,
+		"updated_at": time.Now(),
+	}
+	if unreadFor != 0 {
+		for col, expr := range unreadIncr(unreadFor) {
+			update[col] = expr
+		}
+	}
+	return s.db.WithContext(ctx).Model(&TalkSession{}).Where("id=?", id).Updates(update).Error
+}
+
+// unreadIncr uid 一侧未读 +1：receiver_id 一方计入 un_read_num，send_id 一方计入 send_un_read_num
+func unreadIncr(uid int) map[string]any {
+	return map[string]any{
+		"un_read_num":      gorm.Expr("CASE WHEN receiver_id = ? THEN un_read_num + 1 ELSE un_read_num END", uid),
+		"send_un_read_num": gorm.Expr("CASE WHEN receiver_id <> ? AND send_id = ? THEN send_un_read_num + 1 ELSE send_un_read_num END", uid, uid),
+	}
+}
+
+func (s *gormSessionStore) SetLastText(ctx context.Context, id int, text string) error {
+	return s.db.WithContext(ctx).Model(&TalkSession{}).Where("id=?", id).
+		UpdateColumn("msg_text", text).Error
+}
+
+func (s *gormSessionStore) IncrUnread(ctx context.Context, id, uid int) error {
+	return s.db.WithContext(ctx).Model(&TalkSession{}).Where("id=?", id).
+		UpdateColumns(unreadIncr(uid)).Error
+}
+
+func (s *gormSessionStore) ClearUnread(ctx context.Context, id, uid int) error {
+	db := s.db.WithContext(ctx).Model(&TalkSession{})
+	if err := db.Where("id=? AND receiver_id=?", id, uid).Update("un_read_num", 0).Error; err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Model(&TalkSession{}).Where("id=? AND send_id=? AND receiver_id<>?", id, uid, uid).
+		Update("send_un_read_num", 0).Error
+}
+
+func (s *gormSessionStore) SetStatus(ctx context.Context, id, status int) error {
+	return s.db.WithContext(ctx).Model(&TalkSession{}).Where("id=?", id).Update("status", status).Error
+}
+
+func (s *gormSessionStore) CreateGroup(ctx context.Context, sess *TalkSession, members []SessionMember) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(sess).Error; err != nil {
+			return err
+		}
+		for i := range members {
+			members[i].Sid = sess.ID
+		}
+		return tx.Create(&members).Error
+	})
+}
+
+func (s *gormSessionStore) Member(ctx context.Context, sid, uid int) (*SessionMember, error) {
+	var m SessionMember
+	if err := s.db.WithContext(ctx).Where("sid=? AND user_id=? AND status=1", sid, uid).First(&m).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &m, nil
+}
+
+func (s *gormSessionStore) Members(ctx context.Context, sid int) ([]SessionMember, error) {
+	var list []SessionMember
+	err := s.db.WithContext(ctx).Where("sid=? AND status=1", sid).Order("role asc, joined_at asc").Find(&list).Error
+	return list, err
+}
+
+func (s *gormSessionStore) AddMember(ctx context.Context, sid, uid, role int) error {
+	db := s.db.WithContext(ctx)
+	var m SessionMember
+	err := db.Where("sid=? AND user_id=?", sid, uid).First(&m).Error
+	switch {
+	case err == nil:
+		return db.Model(&m).Updates(map[string]any{
+			"status": 1, "role": role, "un_read_num": 0, "joined_at": time.Now(),
+		}).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return db.Create(&SessionMember{Sid: sid, UserID: uid, Role: role, Status: 1, JoinedAt: time.Now()}).Error
+	default:
+		return err
+	}
+}
+
+func (s *gormSessionStore) RemoveMember(ctx context.Context, sid, uid int) error {
+	return s.db.WithContext(ctx).Model(&SessionMember{}).Where("sid=? AND user_id=?", sid, uid).
+		Updates(map[string]any{"status": 0, "un_read_num": 0}).Error
+}
+
+func (s *gormSessionStore) SetMemberRole(ctx context.Context, sid, uid, role int) error {
+	return s.db.WithContext(ctx).Model(&SessionMember{}).Where("sid=? AND user_id=?", sid, uid).
+		Update("role", role).Error
+}
+
+func (s *gormSessionStore) IncrMemberUnread(ctx context.Context, sid, exceptUID int) error {
+	return s.db.WithContext(ctx).Model(&SessionMember{}).
+		Where("sid=? AND status=1 AND user_id<>?", sid, exceptUID).
+		Update("un_read_num", gorm.Expr("un_read_num + 1")).Error
+}
+
+func (s *gormSessionStore) ClearMemberUnread(ctx context.Context, sid, uid int) error {
+	return s.db.WithContext(ctx).Model(&SessionMember{}).Where("sid=? AND user_id=?", sid, uid).
+		Update("un_read_num", 0).Error
+}
+
+// ---------------------- 用户 ----------------------
+type gormUserStore struct{ db *gorm.DB }
+
+func (s *gormUserStore) List(ctx context.Context) ([]TalkUser, error) {
+	var users []TalkUser
+	err := s.db.WithContext(ctx).Find(&users).Error
+	return users, err
+}
+
+func (s *gormUserStore) Upsert(ctx context.Context, uid int, name, avatar string) error {
+	db := s.db.WithContext(ctx)
+	var u TalkUser
+	err := db.Where("user_id = ?", uid).First(&u).Error
+	switch {
+	case err == nil:
+		// 如昵称/头像有变化则更新
+		update := map[string]any{}
+		if name != "" && name != u.Username {
+			update["username"] = name
+		}
+		if avatar != "" && avatar != u.UserAvatar {
+			update["user_avatar"] = avatar
+		}
+		if len(update) == 0 {
+			return nil
+		}
+		return db.Model(&TalkUser{}).Where("id=?", u.ID).Updates(update).Error
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		// 不存在就创建
+		return db.Create(&TalkUser{Username: name, UserID: uid, UserAvatar: avatar}).Error
+	default:
+		return err
+	}
+}
+
+func (s *gormUserStore) GetByUserIDs(ctx context.Context, uids []int) ([]TalkUser, error) {
+	var users []TalkUser
+	if len(uids) == 0 {
+		return users, nil
+	}
+	err := s.db.WithContext(ctx).Where("user_id IN ?", uids).Find(&users).Error
+	return users, err
+}
+
+func (s *gormUserStore) SetPresence(ctx context.Context, uid int, status string, at time.Time) error {
+	return s.db.WithContext(ctx).Model(&TalkUser{}).Where("user_id=?", uid).
+		Updates(map[string]any{"presence": status, "last_seen_at": at}).Error
+}
+
+// ---------------------- Outbox ----------------------
+type gormOutboxStore struct{ db *gorm.DB }
+
+func (s *gormOutboxStore) Enqueue(ctx context.Context, entries []OutboxEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).Create(&entries).Error
+}
+
+func (s *gormOutboxStore) Claim(ctx context.Context, token string, limit int, staleAfter time.Duration) ([]OutboxEntry, error) {
+	db := s.db.WithContext(ctx)
+	stale := time.Now().Add(-staleAfter)
+	var ids []int64
+	if err := db.Model(&OutboxEntry{}).
+		Where("claim_token = '' OR claimed_at < ?", stale).
+		Order("id asc").Limit(limit).Pluck("id", &ids).Error; err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	// 条件更新保证多节点并发认领时每条只归属一个 token
+	if err := db.Model(&OutboxEntry{}).
+		Where("id IN ? AND (claim_token = '' OR claimed_at < ?)", ids, stale).
+		Updates(map[string]any{
+			"claim_token": token,
+			"claimed_at":  time.Now(),
+			"attempts":    gorm.Expr("attempts + 1"),
+		}).Error; err != nil {
+		return nil, err
+	}
+	var entries []OutboxEntry
+	err := db.Where("claim_token = ?", token).Order("id asc").Find(&entries).Error
+	return entries, err
+}
+
+func (s *gormOutboxStore) Done(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).Where("id IN ?", ids).Delete(&OutboxEntry{}).Error
+}
+
+// ---------------------- 复核报价 ----------------------
+type gormQuoteStore struct{ db *gorm.DB }
+
+func (s *gormQuoteStore) Create(ctx context.Context, q *Quote) error {
+	return s.db.WithContext(ctx).Create(q).Error
+}
+
+func (s *gormQuoteStore) Get(ctx context.Context, id int) (*Quote, error) {
+	var q Quote
+	if err := s.db.WithContext(ctx).First(&q, "id=?", id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &q, nil
+}
+
+func (s *gormQuoteStore) SetMessageID(ctx context.Context, id, messageID int) error {
+	return s.db.WithContext(ctx).Model(&Quote{}).Where("id=?", id).Update("message_id", messageID).Error
+}
+
+func (s *gormQuoteStore) Transition(ctx context.Context, id int, from, to string, counter json.RawMessage) (bool, error) {
+	updates := map[string]any{"state": to}
+	if len(counter) > 0 {
+		updates["counter"] = counter
+	}
+	res := s.db.WithContext(ctx).Model(&Quote{}).Where("id=? AND state=?", id, from).Updates(updates)
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *gormQuoteStore) AddAction(ctx context.Context, a *QuoteAction) error {
+	return s.db.WithContext(ctx).Create(a).Error
+}
+
+func (s *gormQuoteStore) Actions(ctx context.Context, quoteID int) ([]QuoteAction, error) {
+	var list []QuoteAction
+	err := s.db.WithContext(ctx).Where("quote_id=?", quoteID).Order("id asc").Find(&list).Error
+	return list, err
+}
+
+// ---------------------- 分片上传 ----------------------
+type gormUploadStore struct{ db *gorm.DB }
+
+func (s *gormUploadStore) Create(ctx context.Context, u *UploadSession) error {
+	return s.db.WithContext(ctx).Create(u).Error
+}
+
+func (s *gormUploadStore) Get(ctx context.Context, id string) (*UploadSession, error) {
+	var u UploadSession
+	if err := s.db.WithContext(ctx).First(&u, "id=?", id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &u, nil
+}
+
+func (s *gormUploadStore) PutChunk(ctx context.Context, c *UploadChunk) error {
+	db := s.db.WithContext(ctx)
+	res := db.Model(&UploadChunk{}).Where("upload_id=? AND chunk_index=?", c.UploadID, c.ChunkIndex).
+		Updates(map[string]any{"size": c.Size, "sha256": c.Sha256})
+	if res.Error != nil || res.RowsAffected > 0 {
+		return res.Error
+	}
+	return db.Create(c).Error
+}
+
+func (s *gormUploadStore) Chunks(ctx context.Context, uploadID string) ([]UploadChunk, error) {
+	var list []UploadChunk
+	err := s.db.WithContext(ctx).Where("upload_id=?", uploadID).Order("chunk_index asc").Find(&list).Error
+	return list, err
+}
+
+func (s *gormUploadStore) Transition(ctx context.Context, id, from, to string) (bool, error) {
+	res := s.db.WithContext(ctx).Model(&UploadSession{}).Where("id=? AND state=?", id, from).Update("state", to)
+	return res.RowsAffected > 0, res.Error
+}
+
+func (s *gormUploadStore) Complete(ctx context.Context, id string, messageID int) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&UploadSession{}).Where("id=?", id).
+			Updates(map[string]any{"state": UploadStateCompleted, "message_id": messageID}).Error; err != nil {
+			return err
+		}
+		return tx.Where("upload_id=?", id).Delete(&UploadChunk{}).Error
+	})
+}
+
+func (s *gormUploadStore) Expired(ctx context.Context, before time.Time, limit int) ([]UploadSession, error) {
+	var list []UploadSession
+	err := s.db.WithContext(ctx).Where("expires_at < ?", before).Order("expires_at asc").Limit(limit).Find(&list).Error
+	return list, err
+}
+
+func (s *gormUploadStore) Delete(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("upload_id=?", id).Delete(&UploadChunk{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id=?", id).Delete(&UploadSession{}).Error
+	})
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestGormAddReactionIdempotent(t *testing.T) {
+	ctx := useSQLite(t)
+	for i, want := range []bool{true, false} {
+		added, err := store.Messages.AddReaction(ctx, 7, 1, "👍")
+		if err != nil || added != want {
+			t.Fatalf("add #%d: added=%v err=%v, want %v", i+1, added, err, want)
+		}
+	}
+	if added, err := store.Messages.AddReaction(ctx, 7, 2, "👍"); err != nil || !added {
+		t.Fatalf("add by other user: added=%v err=%v", added, err)
+	}
+}
+
+func TestGormHideIdempotent(t *testing.T) {
+	ctx := useSQLite(t)
+	if err := store.Messages.Hide(ctx, 1, []int{5, 6}); err != nil {
+		t.Fatal(err)
+	}
+	// 重复隐藏（含已隐藏的 id）不报错
+	if err := store.Messages.Hide(ctx, 1, []int{6, 7}); err != nil {
+		t.Fatalf("hide again: %v", err)
+	}
+	hidden, err := store.Messages.HiddenIDs(ctx, 1, []int{5, 6, 7, 8})
+	if err != nil || len(hidden) != 3 || hidden[8] {
+		t.Fatalf("hidden = %v, err=%v", hidden, err)
+	}
+}
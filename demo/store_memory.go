@@ -0,0 +1,1018 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ---------------------- 内存实现（测试 / 本地调试） ----------------------
+// 各存储共用一把锁和一份数据，行为与 GORM 实现保持一致（含事务回滚）。
+
+type memoryData struct {
+	mu sync.Mutex
+
+	messages map[int]*TalkMessage
+	msgSeq   int
+
+	sessions map[int]*TalkSession
+	sessSeq  int
+	members  map[int]map[int]*SessionMember // sid -> user_id -> member
+	memSeq   int
+
+	users   map[int]*TalkUser // user_id -> user
+	userSeq int
+
+	userSeqs  map[int]int64          // user_id -> 最新 seq
+	timelines map[int][]UserTimeline // user_id -> 时间线（seq 升序）
+	tlSeq     int64
+
+	outbox    []OutboxEntry
+	outboxSeq int64
+
+	edits   []MessageEdit
+	editSeq int
+	hidden  map[int]map[int]bool // user_id -> message_id
+
+	reactions []MessageReaction // 按添加顺序
+	reactSeq  int
+
+	quotes       map[int]*Quote
+	quoteSeq     int
+	quoteActions []QuoteAction
+	actionSeq    int
+
+	uploads      map[string]*UploadSession
+	uploadChunks map[string]map[int]UploadChunk // upload_id -> 序号 -> 分片
+	chunkSeq     int
+}
+
+func newMemoryStores() *Stores {
+	d := &memoryData{
+		messages:     make(map[int]*TalkMessage),
+		sessions:     make(map[int]*TalkSession),
+		members:      make(map[int]map[int]*SessionMember),
+		users:        make(map[int]*TalkUser),
+		userSeqs:     make(map[int]int64),
+		timelines:    make(map[int][]UserTimeline),
+		hidden:       make(map[int]map[int]bool),
+		quotes:       make(map[int]*Quote),
+		uploads:      make(map[string]*UploadSession),
+		uploadChunks: make(map[string]map[int]UploadChunk),
+	}
+	st := d.stores()
+	st.tx = d.tx
+	return st
+}
+
+func (d *memoryData) stores() *Stores {
+	return &Stores{
+		Messages: &memoryMessageStore{d},
+		Sessions: &memorySessionStore{d},
+		Users:    &memoryUserStore{d},
+		Outbox:   &memoryOutboxStore{d},
+		Quotes:   &memoryQuoteStore{d},
+		Uploads:  &memoryUploadStore{d},
+	}
+}
+
+// tx 事务期间独占数据（其他读写等待），fn 在数据副本上执行，成功后整体替换，返回错误则丢弃副本，
+// 与 GORM 事务的提交/回滚语义一致。嵌套 Tx 直接在同一副本上执行。
+func (d *memoryData) tx(_ context.Context, fn func(st *Stores) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	staged := d.clone()
+	if err := fn(staged.stores()); err != nil {
+		return err
+	}
+	d.replaceWith(staged)
+	return nil
+}
+
+// clone 深拷贝全部数据（调用方持有 d.mu）
+func (d *memoryData) clone() *memoryData {
+	c := &memoryData{
+		messages:     clonePtrMap(d.messages),
+		msgSeq:       d.msgSeq,
+		sessions:     clonePtrMap(d.sessions),
+		sessSeq:      d.sessSeq,
+		members:      make(map[int]map[int]*SessionMember, len(d.members)),
+		memSeq:       d.memSeq,
+		users:        clonePtrMap(d.users),
+		userSeq:      d.userSeq,
+		userSeqs:     make(map[int]int64, len(d.userSeqs)),
+		timelines:    make(map[int][]UserTimeline, len(d.timelines)),
+		tlSeq:        d.tlSeq,
+		outbox:       append([]OutboxEntry(nil), d.outbox...),
+		outboxSeq:    d.outboxSeq,
+		edits:        append([]MessageEdit(nil), d.edits...),
+		editSeq:      d.editSeq,
+		hidden:       make(map[int]map[int]bool, len(d.hidden)),
+		reactions:    append([]MessageReaction(nil), d.reactions...),
+		reactSeq:     d.reactSeq,
+		quotes:       clonePtrMap(d.quotes),
+		quoteSeq:     d.quoteSeq,
+		quoteActions: append([]QuoteAction(nil), d.quoteActions...),
+		actionSeq:    d.actionSeq,
+		uploads:      clonePtrMap(d.uploads),
+		uploadChunks: make(map[string]map[int]UploadChunk, len(d.uploadChunks)),
+		chunkSeq:     d.chunkSeq,
+	}
+	for sid, m := range d.members {
+		c.members[sid] = clonePtrMap(m)
+	}
+	for uid, seq := range d.userSeqs {
+		c.userSeqs[uid] = seq
+	}
+	for uid, tl := range d.timelines {
+		c.timelines[uid] = append([]UserTimeline(nil), tl...)
+	}
+	for uid, ids := range d.hidden {
+		cp := make(map[int]bool, len(ids))
+		for id, v := range ids {
+			cp[id] = v
+		}
+		c.hidden[uid] = cp
+	}
+	for id, chunks := range d.uploadChunks {
+		cp := make(map[int]UploadChunk, len(chunks))
+		for i, ch := range chunks {
+			cp[i] = ch
+		}
+		c.uploadChunks[id] = cp
+	}
+	return c
+}
+
+// replaceWith 提交事务副本（调用方持有 d.mu）
+func (d *memoryData) replaceWith(c *memoryData) {
+	d.messages, d.msgSeq = c.messages, c.msgSeq
+	d.sessions, d.sessSeq = c.sessions, c.sessSeq
+	d.members, d.memSeq = c.members, c.memSeq
+	d.users, d.userSeq = c.users, c.userSeq
+	d.userSeqs, d.timelines, d.tlSeq = c.userSeqs, c.timelines, c.tlSeq
+	d.outbox, d.outboxSeq = c.outbox, c.outboxSeq
+	d.edits, d.editSeq, d.hidden = c.edits, c.editSeq, c.hidden
+	d.reactions, d.reactSeq = c.reactions, c.reactSeq
+	d.quotes, d.quoteSeq = c.quotes, c.quoteSeq
+	d.quoteActions, d.actionSeq = c.quoteActions, c.actionSeq
+	d.uploads, d.uploadChunks, d.chunkSeq = c.uploads, c.uploadChunks, c.chunkSeq
+}
+
+// clonePtrMap 复制 map 及其指向的记录
+func clonePtrMap[K comparable, V any](m map[K]*V) map[K]*V {
+	c := make(map[K]*V, len(m))
+	for k, v := range m {
+		cp := *v
+		c[k] = &cp
+	}
+	return c
+}
+
+// ---------------------- 消息 ----------------------
+type memoryMessageStore struct{ d *memoryData }
+
+func (s *memoryMessageStore) Create(_ context.Context, msg *TalkMessage) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if msg.ClientMsgID != nil && s.findByClientMsgIDLocked(msg.SendID, *msg.ClientMsgID) != nil {
+		return errors.New("duplicate client_msg_id")
+	}
+	s.d.msgSeq++
+	msg.ID = s.d.msgSeq
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Now()
+	}
+	if msg.Status == 0 {
+		msg.Status = MsgStatusSent
+	}
+	m := *msg
+	s.d.messages[m.ID] = &m
+	return nil
+}
+
+func (s *memoryMessageStore) Get(_ context.Context, id int) (*TalkMessage, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	m, ok := s.d.messages[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *m
+	return &cp, nil
+}
+
+func (s *memoryMessageStore) GetByIDs(_ context.Context, ids []int) ([]TalkMessage, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	list := make([]TalkMessage, 0, len(ids))
+	for _, id := range ids {
+		if m, ok := s.d.messages[id]; ok {
+			list = append(list, *m)
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryMessageStore) FindByClientMsgID(_ context.Context, sendID int, clientMsgID string) (*TalkMessage, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	m := s.findByClientMsgIDLocked(sendID, clientMsgID)
+	if m == nil {
+		return nil, ErrNotFound
+	}
+	cp := *m
+	return &cp, nil
+}
+
+func (s *memoryMessageStore) findByClientMsgIDLocked(sendID int, clientMsgID string) *TalkMessage {
+	for _, m := range s.d.messages {
+		if m.SendID == sendID && m.ClientMsgID != nil && *m.ClientMsgID == clientMsgID {
+			return m
+		}
+	}
+	return nil
+}
+
+func (s *memoryMessageStore) List(_ context.Context, q MessageQuery) ([]TalkMessage, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var list []TalkMessage
+	for _, m := range s.d.messages {
+		if m.Sid != q.Sid {
+			continue
+		}
+		if q.AfterID > 0 && m.ID <= q.AfterID {
+			continue
+		}
+		if q.AfterID == 0 && q.BeforeID > 0 && m.ID >= q.BeforeID {
+			continue
+		}
+		list = append(list, *m)
+	}
+	asc := q.AfterID > 0
+	sort.Slice(list, func(i, j int) bool {
+		if asc {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].ID > list[j].ID
+	})
+	if q.Limit > 0 && len(list) > q.Limit {
+		list = list[:q.Limit]
+	}
+	return list, nil
+}
+
+func (s *memoryMessageStore) AdvanceStatus(_ context.Context, f StatusFilter, status int) ([]TalkMessage, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var ids map[int]bool
+	if len(f.IDs) > 0 {
+		ids = make(map[int]bool, len(f.IDs))
+		for _, id := range f.IDs {
+			ids[id] = true
+		}
+	}
+	var list []TalkMessage
+	for _, m := range s.d.messages {
+		if m.ReceiverID != f.ReceiverID || m.Status >= status {
+			continue
+		}
+		if ids != nil && !ids[m.ID] {
+			continue
+		}
+		if f.Sid > 0 && m.Sid != f.Sid {
+			continue
+		}
+		list = append(list, *m)
+		m.Status = status
+		if status == MsgStatusRead {
+			m.IsRead = 1
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (s *memoryMessageStore) Scan(_ context.Context, afterID, limit int) ([]TalkMessage, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var list []TalkMessage
+	for _, m := range s.d.messages {
+		if m.ID > afterID {
+			list = append(list, *m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (s *memoryMessageStore) Replies(_ context.Context, rootID, afterID, limit int) ([]TalkMessage, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var list []TalkMessage
+	for _, m := range s.d.messages {
+		if m.ReplyToID == rootID && m.ID > afterID {
+			list = append(list, *m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (s *memoryMessageStore) Recall(_ context.Context, id int) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if m, ok := s.d.messages[id]; ok {
+		m.IsRecalled = 1
+		m.Content = ""
+		m.Payload = nil
+	}
+	return nil
+}
+
+func (s *memoryMessageStore) Edit(_ context.Context, id, editorID int, content string, payload json.RawMessage) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	m, ok := s.d.messages[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.d.editSeq++
+	s.d.edits = append(s.d.edits, MessageEdit{
+		ID: s.d.editSeq, MessageID: id, EditorID: editorID, Content: m.Content, CreatedAt: time.Now(),
+	})
+	now := time.Now()
+	m.Content = content
+	m.Payload = payload
+	m.EditedAt = &now
+	return nil
+}
+
+func (s *memoryMessageStore) SetPayload(_ context.Context, id int, content string, payload json.RawMessage) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if m, ok := s.d.messages[id]; ok {
+		m.Content = content
+		m.Payload = payload
+	}
+	return nil
+}
+
+func (s *memoryMessageStore) EditHistory(_ context.Context, id int) ([]MessageEdit, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var list []MessageEdit
+	for _, e := range s.d.edits {
+		if e.MessageID == id {
+			list = append(list, e)
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryMessageStore) Hide(_ context.Context, uid int, ids []int) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if s.d.hidden[uid] == nil {
+		s.d.hidden[uid] = make(map[int]bool)
+	}
+	for _, id := range ids {
+		s.d.hidden[uid][id] = true
+	}
+	return nil
+}
+
+func (s *memoryMessageStore) HiddenIDs(_ context.Context, uid int, ids []int) (map[int]bool, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	hidden := make(map[int]bool)
+	for _, id := range ids {
+		if s.d.hidden[uid][id] {
+			hidden[id] = true
+		}
+	}
+	return hidden, nil
+}
+
+func (s *memoryMessageStore) AddReaction(_ context.Context, id, uid int, emoji string) (bool, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	for _, r := range s.d.reactions {
+		if r.MessageID == id && r.UserID == uid && r.Emoji == emoji {
+			return false, nil
+		}
+	}
+	s.d.reactSeq++
+	s.d.reactions = append(s.d.reactions, MessageReaction{ID: s.d.reactSeq, MessageID: id, UserID: uid, Emoji: emoji, CreatedAt: time.Now()})
+	return true, nil
+}
+
+func (s *memoryMessageStore) RemoveReaction(_ context.Context, id, uid int, emoji string) (bool, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	for i, r := range s.d.reactions {
+		if r.MessageID == id && r.UserID == uid && r.Emoji == emoji {
+			s.d.reactions = append(s.d.reactions[:i], s.d.reactions[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryMessageStore) Reactions(_ context.Context, uid int, ids []int) (map[int][]ReactionCount, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	want := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	result := make(map[int][]ReactionCount)
+	for _, r := range s.d.reactions {
+		if !want[r.MessageID] {
+			continue
+		}
+		list := result[r.MessageID]
+		i := 0
+		for i < len(list) && list[i].Emoji != r.Emoji {
+			i++
+		}
+		if i == len(list) {
+			list = append(list, ReactionCount{Emoji: r.Emoji})
+		}
+		list[i].Count++
+		list[i].Reacted = list[i].Reacted || r.UserID == uid
+		result[r.MessageID] = list
+	}
+	return result, nil
+}
+
+func (s *memoryMessageStore) AppendTimeline(_ context.Context, msg *TalkMessage, uids []int) (map[int]int64, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	seqs := make(map[int]int64, len(uids))
+	for _, uid := range uids {
+		if _, ok := seqs[uid]; ok || uid == 0 {
+			continue
+		}
+		s.d.userSeqs[uid]++
+		s.d.tlSeq++
+		seq := s.d.userSeqs[uid]
+		s.d.timelines[uid] = append(s.d.timelines[uid], UserTimeline{
+			ID: s.d.tlSeq, UserID: uid, Seq: seq, MessageID: msg.ID, Sid: msg.Sid, CreatedAt: time.Now(),
+		})
+		seqs[uid] = seq
+	}
+	return seqs, nil
+}
+
+func (s *memoryMessageStore) Timeline(_ context.Context, uid int, afterSeq int64, limit int) ([]UserTimeline, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var rows []UserTimeline
+	for _, t := range s.d.timelines[uid] {
+		if t.Seq <= afterSeq {
+			continue
+		}
+		rows = append(rows, t)
+		if limit > 0 && len(rows) >= limit {
+			break
+		}
+	}
+	return rows, nil
+}
+
+// ---------------------- 会话 ----------------------
+type memorySessionStore struct{ d *memoryData }
+
+func (s *memorySessionStore) Create(_ context.Context, sess *TalkSession) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.createLocked(sess)
+	return nil
+}
+
+func (s *memorySessionStore) createLocked(sess *TalkSession) {
+	s.d.sessSeq++
+	sess.ID = s.d.sessSeq
+	if sess.Type == 0 {
+		sess.Type = SessionTypeSingle
+	}
+	sess.UpdatedAt = time.Now()
+	cp := *sess
+	s.d.sessions[cp.ID] = &cp
+}
+
+func (s *memorySessionStore) Get(_ context.Context, id int) (*TalkSession, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	sess, ok := s.d.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *sess
+	return &cp, nil
+}
+
+func (s *memorySessionStore) FindPair(_ context.Context, sendID, receiverID int) (*TalkSession, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var found *TalkSession
+	for _, sess := range s.d.sessions {
+		if sess.SendID == sendID && sess.ReceiverID == receiverID && (found == nil || sess.ID < found.ID) {
+			found = sess
+		}
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	cp := *found
+	return &cp, nil
+}
+
+func (s *memorySessionStore) ListForUser(_ context.Context, uid int) ([]TalkSession, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var list []TalkSession
+	for _, sess := range s.d.sessions {
+		if sess.Status != 1 {
+			continue
+		}
+		cp := sess.viewedBy(uid)
+		if m := s.memberLocked(sess.ID, uid); m != nil {
+			if sess.Type == SessionTypeGroup {
+				cp.UnReadNum = m.UnReadNum
+			}
+			list = append(list, cp)
+			continue
+		}
+		if sess.SendID == uid || sess.ReceiverID == uid {
+			list = append(list, cp)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UpdatedAt.After(list[j].UpdatedAt) })
+	return list, nil
+}
+
+func (s *memorySessionStore) UpdateLastMessage(_ context.Context, id int, text string, unreadFor int) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if sess, ok := s.d.sessions[id]; ok {
+		sess.MsgText = text
+		sess.UpdatedAt = time.Now()
+		if unreadFor != 0 {
+			incrUnreadLocked(sess, unreadFor)
+		}
+	}
+	return nil
+}
+
+// incrUnreadLocked uid 一侧未读 +1，与 gorm 实现的 unreadIncr 一致
+func incrUnreadLocked(sess *TalkSession, uid int) {
+	switch uid {
+	case sess.ReceiverID:
+		sess.UnReadNum++
+	case sess.SendID:
+		sess.SendUnReadNum++
+	}
+}
+
+func (s *memorySessionStore) SetLastText(_ context.Context, id int, text string) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if sess, ok := s.d.sessions[id]; ok {
+		sess.MsgText = text
+	}
+	return nil
+}
+
+func (s *memorySessionStore) IncrUnread(_ context.Context, id, uid int) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if sess, ok := s.d.sessions[id]; ok {
+		incrUnreadLocked(sess, uid)
+	}
+	return nil
+}
+
+func (s *memorySessionStore) ClearUnread(_ context.Context, id, uid int) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if sess, ok := s.d.sessions[id]; ok {
+		switch uid {
+		case sess.ReceiverID:
+			sess.UnReadNum = 0
+		case sess.SendID:
+			sess.SendUnReadNum = 0
+		}
+	}
+	return nil
+}
+
+func (s *memorySessionStore) SetStatus(_ context.Context, id, status int) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if sess, ok := s.d.sessions[id]; ok {
+		sess.Status = status
+	}
+	return nil
+}
+
+func (s *memorySessionStore) CreateGroup(_ context.Context, sess *TalkSession, members []SessionMember) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.createLocked(sess)
+	for i := range members {
+		members[i].Sid = sess.ID
+		s.putMemberLocked(members[i])
+	}
+	return nil
+}
+
+func (s *memorySessionStore) putMemberLocked(m SessionMember) {
+	if s.d.members[m.Sid] == nil {
+		s.d.members[m.Sid] = make(map[int]*SessionMember)
+	}
+	if old, ok := s.d.members[m.Sid][m.UserID]; ok {
+		m.ID = old.ID
+	} else {
+		s.d.memSeq++
+		m.ID = s.d.memSeq
+	}
+	m.UpdatedAt = time.Now()
+	s.d.members[m.Sid][m.UserID] = &m
+}
+
+func (s *memorySessionStore) memberLocked(sid, uid int) *SessionMember {
+	m, ok := s.d.members[sid][uid]
+	if !ok || m.Status != 1 {
+		return nil
+	}
+	return m
+}
+
+func (s *memorySessionStore) Member(_ context.Context, sid, uid int) (*SessionMember, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	m := s.memberLocked(sid, uid)
+	if m == nil {
+		return nil, ErrNotFound
+	}
+	cp := *m
+	return &cp, nil
+}
+
+func (s *memorySessionStore) Members(_ context.Context, sid int) ([]SessionMember, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var list []SessionMember
+	for _, m := range s.d.members[sid] {
+		if m.Status == 1 {
+			list = append(list, *m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Role != list[j].Role {
+			return list[i].Role < list[j].Role
+		}
+		return list[i].JoinedAt.Before(list[j].JoinedAt)
+	})
+	return list, nil
+}
+
+func (s *memorySessionStore) AddMember(_ context.Context, sid, uid, role int) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.putMemberLocked(SessionMember{Sid: sid, UserID: uid, Role: role, Status: 1, JoinedAt: time.Now()})
+	return nil
+}
+
+func (s *memorySessionStore) RemoveMember(_ context.Context, sid, uid int) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if m, ok := s.d.members[sid][uid]; ok {
+		m.Status = 0
+		m.UnReadNum = 0
+	}
+	return nil
+}
+
+func (s *memorySessionStore) SetMemberRole(_ context.Context, sid, uid, role int) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if m, ok := s.d.members[sid][uid]; ok {
+		m.Role = role
+	}
+	return nil
+}
+
+func (s *memorySessionStore) IncrMemberUnread(_ context.Context, sid, exceptUID int) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	for uid, m := range s.d.members[sid] {
+		if m.Status == 1 && uid != exceptUID {
+			m.UnReadNum++
+		}
+	}
+	return nil
+}
+
+func (s *memorySessionStore) ClearMemberUnread(_ context.Context, sid, uid int) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if m, ok := s.d.members[sid][uid]; ok {
+		m.UnReadNum = 0
+	}
+	return nil
+}
+
+// ---------------------- 用户 ----------------------
+type memoryUserStore struct{ d *memoryData }
+
+func (s *memoryUserStore) List(_ context.Context) ([]TalkUser, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	list := make([]TalkUser, 0, len(s.d.users))
+	for _, u := range s.d.users {
+		list = append(list, *u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (s *memoryUserStore) Upsert(_ context.Context, uid int, name, avatar string) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if u, ok := s.d.users[uid]; ok {
+		if name != "" {
+			u.Username = name
+		}
+		if avatar != "" {
+			u.UserAvatar = avatar
+		}
+		return nil
+	}
+	s.d.userSeq++
+	s.d.users[uid] = &TalkUser{ID: s.d.userSeq, UserID: uid, Username: name, UserAvatar: avatar}
+	return nil
+}
+
+func (s *memoryUserStore) GetByUserIDs(_ context.Context, uids []int) ([]TalkUser, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var list []TalkUser
+	for _, uid := range uids {
+		if u, ok := s.d.users[uid]; ok {
+			list = append(list, *u)
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryUserStore) SetPresence(_ context.Context, uid int, status string, at time.Time) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if u, ok := s.d.users[uid]; ok {
+		u.Presence = status
+		u.LastSeenAt = &at
+	}
+	return nil
+}
+
+// ---------------------- Outbox ----------------------
+type memoryOutboxStore struct{ d *memoryData }
+
+func (s *memoryOutboxStore) Enqueue(_ context.Context, entries []OutboxEntry) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	for i := range entries {
+		s.d.outboxSeq++
+		entries[i].ID = s.d.outboxSeq
+		entries[i].CreatedAt = time.Now()
+		s.d.outbox = append(s.d.outbox, entries[i])
+	}
+	return nil
+}
+
+func (s *memoryOutboxStore) Claim(_ context.Context, token string, limit int, staleAfter time.Duration) ([]OutboxEntry, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	now := time.Now()
+	var list []OutboxEntry
+	for i := range s.d.outbox {
+		e := &s.d.outbox[i]
+		if e.ClaimToken != "" && e.ClaimedAt != nil && e.ClaimedAt.After(now.Add(-staleAfter)) {
+			continue
+		}
+		e.ClaimToken = token
+		e.ClaimedAt = &now
+		e.Attempts++
+		list = append(list, *e)
+		if len(list) >= limit {
+			break
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryOutboxStore) Done(_ context.Context, ids []int64) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	done := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		done[id] = true
+	}
+	rest := s.d.outbox[:0]
+	for _, e := range s.d.outbox {
+		if !done[e.ID] {
+			rest = append(rest, e)
+		}
+	}
+	s.d.outbox = rest
+	return nil
+}
+
+// ---------------------- 复核报价 ----------------------
+type memoryQuoteStore struct{ d *memoryData }
+
+func (s *memoryQuoteStore) Create(_ context.Context, q *Quote) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.quoteSeq++
+	q.ID = s.d.quoteSeq
+	q.CreatedAt = time.Now()
+	q.UpdatedAt = q.CreatedAt
+	cp := *q
+	s.d.quotes[q.ID] = &cp
+	return nil
+}
+
+func (s *memoryQuoteStore) Get(_ context.Context, id int) (*Quote, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	q, ok := s.d.quotes[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *q
+	return &cp, nil
+}
+
+func (s *memoryQuoteStore) SetMessageID(_ context.Context, id, messageID int) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if q, ok := s.d.quotes[id]; ok {
+		q.MessageID = messageID
+	}
+	return nil
+}
+
+func (s *memoryQuoteStore) Transition(_ context.Context, id int, from, to string, counter json.RawMessage) (bool, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	q, ok := s.d.quotes[id]
+	if !ok || q.State != from {
+		return false, nil
+	}
+	q.State = to
+	if len(counter) > 0 {
+		q.Counter = counter
+	}
+	q.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func (s *memoryQuoteStore) AddAction(_ context.Context, a *QuoteAction) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	s.d.actionSeq++
+	a.ID = s.d.actionSeq
+	a.CreatedAt = time.Now()
+	s.d.quoteActions = append(s.d.quoteActions, *a)
+	return nil
+}
+
+func (s *memoryQuoteStore) Actions(_ context.Context, quoteID int) ([]QuoteAction, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var list []QuoteAction
+	for _, a := range s.d.quoteActions {
+		if a.QuoteID == quoteID {
+			list = append(list, a)
+		}
+	}
+	return list, nil
+}
+
+// ---------------------- 分片上传 ----------------------
+type memoryUploadStore struct{ d *memoryData }
+
+func (s *memoryUploadStore) Create(_ context.Context, u *UploadSession) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if _, ok := s.d.uploads[u.ID]; ok {
+		return errors.New("duplicate upload id")
+	}
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = u.CreatedAt
+	cp := *u
+	s.d.uploads[u.ID] = &cp
+	return nil
+}
+
+func (s *memoryUploadStore) Get(_ context.Context, id string) (*UploadSession, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	u, ok := s.d.uploads[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *u
+	return &cp, nil
+}
+
+func (s *memoryUploadStore) PutChunk(_ context.Context, c *UploadChunk) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	chunks := s.d.uploadChunks[c.UploadID]
+	if chunks == nil {
+		chunks = make(map[int]UploadChunk)
+		s.d.uploadChunks[c.UploadID] = chunks
+	}
+	if old, ok := chunks[c.ChunkIndex]; ok {
+		c.ID, c.CreatedAt = old.ID, old.CreatedAt
+	} else {
+		s.d.chunkSeq++
+		c.ID, c.CreatedAt = s.d.chunkSeq, time.Now()
+	}
+	chunks[c.ChunkIndex] = *c
+	return nil
+}
+
+func (s *memoryUploadStore) Chunks(_ context.Context, uploadID string) ([]UploadChunk, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	list := make([]UploadChunk, 0, len(s.d.uploadChunks[uploadID]))
+	for _, c := range s.d.uploadChunks[uploadID] {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ChunkIndex < list[j].ChunkIndex })
+	return list, nil
+}
+
+func (s *memoryUploadStore) Transition(_ context.Context, id, from, to string) (bool, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	u, ok := s.d.uploads[id]
+	if !ok || u.State != from {
+		return false, nil
+	}
+	u.State = to
+	u.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func (s *memoryUploadStore) Complete(_ context.Context, id string, messageID int) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if u, ok := s.d.uploads[id]; ok {
+		u.State = UploadStateCompleted
+		u.MessageID = messageID
+		u.UpdatedAt = time.Now()
+	}
+	delete(s.d.uploadChunks, id)
+	return nil
+}
+
+func (s *memoryUploadStore) Expired(_ context.Context, before time.Time, limit int) ([]UploadSession, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var list []UploadSession
+	for _, u := range s.d.uploads {
+		if u.ExpiresAt.Before(before) {
+			list = append(list, *u)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ExpiresAt.Before(list[j].ExpiresAt) })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (s *memoryUploadStore) Delete(_ context.Context, id string) error {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	delete(s.d.uploads, id)
+	delete(s.d.uploadChunks, id)
+	return nil
+}
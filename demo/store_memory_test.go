@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryTxRollback(t *testing.T) {
+	ctx := context.Background()
+	st := newMemoryStores()
+	sess := &TalkSession{SendID: 1, ReceiverID: 2, Status: 1, Type: 1, UpdatedAt: time.Now()}
+	if err := st.Sessions.Create(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+
+	// fn 返回错误：消息、会话更新、时间线与 outbox 全部回滚
+	errAbort := errors.New("abort")
+	err := st.Tx(ctx, func(tx *Stores) error {
+		msg := &TalkMessage{Sid: sess.ID, SendID: 1, ReceiverID: 2, MsgType: MsgTypeText, Content: "x"}
+		if err := tx.Messages.Create(ctx, msg); err != nil {
+			return err
+		}
+		if err := tx.Sessions.UpdateLastMessage(ctx, sess.ID, "x", 2); err != nil {
+			return err
+		}
+		if _, err := tx.Messages.AppendTimeline(ctx, msg, []int{1, 2}); err != nil {
+			return err
+		}
+		var batch outboxBatch
+		batch.push(2, map[string]any{"event": "im.message"})
+		if err := batch.save(ctx, tx); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("Tx err = %v, want abort", err)
+	}
+	if _, err := st.Messages.Get(ctx, 1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("message after rollback: err=%v, want ErrNotFound", err)
+	}
+	got, _ := st.Sessions.Get(ctx, sess.ID)
+	if got.MsgText != "" || got.UnReadNum != 0 {
+		t.Fatalf("session after rollback: %+v", got)
+	}
+	if tl, _ := st.Messages.Timeline(ctx, 2, 0, 10); len(tl) != 0 {
+		t.Fatalf("timeline after rollback: %d rows", len(tl))
+	}
+	if entries, _ := st.Outbox.Claim(ctx, "t", 10, time.Minute); len(entries) != 0 {
+		t.Fatalf("outbox after rollback: %d entries", len(entries))
+	}
+
+	// 成功提交后可见，自增 ID 与未回滚时一致
+	err = st.Tx(ctx, func(tx *Stores) error {
+		msg := &TalkMessage{Sid: sess.ID, SendID: 1, ReceiverID: 2, MsgType: MsgTypeText, Content: "y"}
+		if err := tx.Messages.Create(ctx, msg); err != nil {
+			return err
+		}
+		return tx.Sessions.UpdateLastMessage(ctx, sess.ID, "y", 2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := st.Messages.Get(ctx, 1)
+	if err != nil || msg.Content != "y" {
+		t.Fatalf("message after commit: %+v, %v", msg, err)
+	}
+	got, _ = st.Sessions.Get(ctx, sess.ID)
+	if got.MsgText != "y" || got.UnReadNum != 1 {
+		t.Fatalf("session after commit: %+v", got)
+	}
+}
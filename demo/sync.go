@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// ---------------------- 增量同步：每用户单调递增序号 ----------------------
+// 每条消息为发送方和接收方各分配一个用户级 seq，写入 user_timeline。
+// 客户端记住收到的最大 seq，重连后请求 "seq > N" 即可拿到所有会话中错过的消息，
+// 不再依赖 is_read 判断哪些消息需要补发。
+
+// 用户序号分配表
+type UserSeq struct {
+	UserID int   `gorm:"primaryKey;autoIncrement:false;column:user_id" json:"user_id"`
+	Seq    int64 `gorm:"column:seq" json:"seq"`
+}
+
+func (UserSeq) TableName() string { return "user_seq" }
+
+// 用户消息时间线
+type UserTimeline struct {
+	ID        int64     `gorm:"primaryKey;column:id" json:"id"`
+	UserID    int       `gorm:"column:user_id;uniqueIndex:uk_user_seq,priority:1" json:"user_id"`
+	Seq       int64     `gorm:"column:seq;uniqueIndex:uk_user_seq,priority:2" json:"seq"`
+	MessageID int       `gorm:"column:message_id" json:"message_id"`
+	Sid       int       `gorm:"column:sid" json:"sid"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (UserTimeline) TableName() string { return "user_timeline" }
+
+const (
+	syncDefaultLimit = 100
+	syncMaxLimit     = 500
+)
+
+// syncMessages 返回用户 seq > afterSeq 的消息（按 seq 升序），以及是否还有更多。
+// 只读 uid 自己的时间线：时间线只在写消息时为当时的会话参与者追加，不需要再按会话校验
+func syncMessages(ctx context.Context, uid int, afterSeq int64, limit int) ([]TalkMessage, bool, *bizError) {
+	if uid == 0 {
+		return nil, false, newBizError(400, "参数错误")
+	}
+	if limit <= 0 {
+		limit = syncDefaultLimit
+	}
+	if limit > syncMaxLimit {
+		limit = syncMaxLimit
+	}
+
+	rows, err := store.Messages.Timeline(ctx, uid, afterSeq, limit+1)
+	if err != nil {
+		return nil, false, newBizError(500, "查询失败")
+	}
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
+	if len(rows) == 0 {
+		return []TalkMessage{}, false, nil
+	}
+
+	ids := make([]int, 0, len(rows))
+	for _, t := range rows {
+		ids = append(ids, t.MessageID)
+	}
+	msgs, err := store.Messages.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, false, newBizError(500, "查询失败")
+	}
+	byID := make(map[int]TalkMessage, len(msgs))
+	for _, m := range msgs {
+		byID[m.ID] = m
+	}
+
+	hidden, _ := store.Messages.HiddenIDs(ctx, uid, ids)
+	list := make([]TalkMessage, 0, len(rows))
+	for _, t := range rows {
+		if hidden[t.MessageID] {
+			continue
+		}
+		m, ok := byID[t.MessageID]
+		if !ok {
+			continue
+		}
+		m.Seq = t.Seq
+		list = append(list, m)
+	}
+	fillQuotes(ctx, list)
+	fillReactions(ctx, uid, list)
+	signMessageURLs(list)
+	return list, hasMore, nil
+}
+
+// 增量同步
+// POST /talk/message/sync
+// body: { "user_id":1, "after_seq":120, "limit":100 }
+func messageSyncHandler(r *ghttp.Request) {
+	var req struct {
+		UserID   int   `json:"user_id"`
+		AfterSeq int64 `json:"after_seq"`
+		Limit    int   `json:"limit"`
+	}
+	if err := r.Parse(&req); err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "msg": "参数错误"})
+		return
+	}
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	list, hasMore, bizErr := syncMessages(r.Context(), req.UserID, req.AfterSeq, req.Limit)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "msg": bizErr.Msg})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "msg": "success", "data": list, "has_more": hasMore})
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+// ---------------------- 正在输入 ----------------------
+// 客户端上行 typing.start / typing.stop，转发给会话其他成员，不落库：
+//   - 下行统一为 "typing" 事件，data.action 为 start / stop；同一会话同一用户在出站队列中合并，只保留最新状态
+//   - typing.start 在 throttle 内重复上报不重复转发，只刷新过期时间
+//   - 超过 ttl 未再上报自动转发 stop（客户端断线、切后台等）
+// 成员列表按会话缓存 memberCacheTTL，避免每次按键都查库：
+//   - 本节点加人 / 退群 / 踢人后立即失效（其他节点的缓存最多滞后 memberCacheTTL）
+//   - 过期条目定期清理，不会随会话数无限增长
+
+type typingKey struct {
+	sid int
+	uid int
+}
+
+type typingState struct {
+	lastSent time.Time
+	timer    *time.Timer
+}
+
+type typingMembers struct {
+	uids     []int
+	loadedAt time.Time
+}
+
+type typingTracker struct {
+	mu       sync.Mutex
+	active   map[typingKey]*typingState
+	members  map[int]*typingMembers // sid -> 成员缓存
+	throttle time.Duration          // 重复 start 的最小转发间隔
+	ttl      time.Duration          // 未再上报多久自动 stop
+
+	memberCacheTTL time.Duration
+}
+
+var typing = &typingTracker{
+	active:         make(map[typingKey]*typingState),
+	members:        make(map[int]*typingMembers),
+	throttle:       3 * time.Second,
+	ttl:            6 * time.Second,
+	memberCacheTTL: 30 * time.Second,
+}
+
+func initTyping(ctx context.Context) {
+	typing.throttle = time.Duration(g.Cfg().MustGet(ctx, "typing.throttle", 3000).Int()) * time.Millisecond
+	typing.ttl = time.Duration(g.Cfg().MustGet(ctx, "typing.ttl", 6).Int()) * time.Second
+	typing.memberCacheTTL = time.Duration(g.Cfg().MustGet(ctx, "typing.memberCacheTTL", 30).Int()) * time.Second
+	if typing.memberCacheTTL <= 0 {
+		typing.memberCacheTTL = 30 * time.Second
+	}
+	go typing.runEvictor(context.Background())
+}
+
+// invalidate 群成员变化后丢弃会话的成员缓存
+func (t *typingTracker) invalidate(sid int) {
+	t.mu.Lock()
+	delete(t.members, sid)
+	t.mu.Unlock()
+}
+
+// runEvictor 定期清理过期的成员缓存
+func (t *typingTracker) runEvictor(ctx context.Context) {
+	ticker := time.NewTicker(t.memberCacheTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		t.evictExpired(time.Now())
+	}
+}
+
+func (t *typingTracker) evictExpired(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for sid, m := range t.members {
+		if now.Sub(m.loadedAt) >= t.memberCacheTTL {
+			delete(t.members, sid)
+		}
+	}
+}
+
+// sessionMembers 返回会话成员（带缓存）
+func (t *typingTracker) sessionMembers(ctx context.Context, sid int) ([]int, error) {
+	t.mu.Lock()
+	cached := t.members[sid]
+	t.mu.Unlock()
+	if cached != nil && time.Since(cached.loadedAt) < t.memberCacheTTL {
+		return cached.uids, nil
+	}
+
+	uids, err := sessionParticipants(ctx, store, sid)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.members[sid] = &typingMembers{uids: uids, loadedAt: time.Now()}
+	t.mu.Unlock()
+	return uids, nil
+}
+
+// start 处理 typing.start：throttle 内只刷新过期时间
+func (t *typingTracker) start(sid, uid int, members []int) {
+	key := typingKey{sid, uid}
+	now := time.Now()
+
+	t.mu.Lock()
+	st := t.active[key]
+	if st == nil {
+		st = &typingState{}
+		t.active[key] = st
+	}
+	forward := now.Sub(st.lastSent) >= t.throttle
+	if forward {
+		st.lastSent = now
+	}
+	if st.timer != nil {
+		st.timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(t.ttl, func() {
+		t.mu.Lock()
+		cur := t.active[key]
+		if cur == nil || cur.timer != timer {
+			t.mu.Unlock()
+			return
+		}
+		delete(t.active, key)
+		t.mu.Unlock()
+		t.forward(sid, uid, members, "stop")
+	})
+	st.timer = timer
+	t.mu.Unlock()
+
+	if forward {
+		t.forward(sid, uid, members, "start")
+	}
+}
+
+// stop 处理 typing.stop，未在输入时忽略
+func (t *typingTracker) stop(sid, uid int, members []int) {
+	key := typingKey{sid, uid}
+	t.mu.Lock()
+	st := t.active[key]
+	if st == nil {
+		t.mu.Unlock()
+		return
+	}
+	st.timer.Stop()
+	delete(t.active, key)
+	t.mu.Unlock()
+	t.forward(sid, uid, members, "stop")
+}
+
+func (t *typingTracker) forward(sid, uid int, members []int, action string) {
+	payload := coalesced(coalesceKey("typing", sid, uid), map[string]any{
+		"event": "typing",
+		"sid":   sid,
+		"data": map[string]any{
+			"sid":       sid,
+			"user_id":   uid,
+			"action":    action,
+			"expire_in": int(t.ttl.Seconds()), // start 后超过该秒数未收到 stop 视为已停止
+		},
+	})
+	for _, m := range members {
+		if m != uid {
+			cluster.Push(m, payload)
+		}
+	}
+}
+
+// typing.start / typing.stop 正在输入，成功不回 ack，出错时回 ack 错误
+// data: { "session_id":1001 }
+func wsTyping(ctx context.Context, c *Client, in *wsFrame) {
+	var req struct {
+		SessionID int `json:"session_id"`
+	}
+	if err := json.Unmarshal(in.Data, &req); err != nil || req.SessionID == 0 {
+		sendAckError(c, in, newBizError(400, "参数错误"))
+		return
+	}
+	members, err := typing.sessionMembers(ctx, req.SessionID)
+	if err != nil {
+		sendAckError(c, in, newBizError(404, "会话不存在"))
+		return
+	}
+	isMember := false
+	for _, m := range members {
+		if m == c.UserID {
+			isMember = true
+			break
+		}
+	}
+	if !isMember {
+		sendAckError(c, in, newBizError(403, "你不在该会话中"))
+		return
+	}
+
+	if in.Event == "typing.start" {
+		typing.start(req.SessionID, c.UserID, members)
+	} else {
+		typing.stop(req.SessionID, c.UserID, members)
+	}
+}
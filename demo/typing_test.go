@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTypingMemberCacheEviction(t *testing.T) {
+	tr := &typingTracker{
+		active:         make(map[typingKey]*typingState),
+		members:        make(map[int]*typingMembers),
+		memberCacheTTL: 30 * time.Second,
+	}
+	now := time.Now()
+	tr.members[1] = &typingMembers{uids: []int{1, 2}, loadedAt: now.Add(-time.Minute)}
+	tr.members[2] = &typingMembers{uids: []int{1, 3}, loadedAt: now}
+	tr.members[3] = &typingMembers{uids: []int{2, 3}, loadedAt: now}
+
+	tr.evictExpired(now)
+	if _, ok := tr.members[1]; ok {
+		t.Fatal("expired entry was not evicted")
+	}
+	if len(tr.members) != 2 {
+		t.Fatalf("members = %d, want 2", len(tr.members))
+	}
+	tr.invalidate(2)
+	if _, ok := tr.members[2]; ok {
+		t.Fatal("invalidated entry still cached")
+	}
+}
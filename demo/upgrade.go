@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gorilla/websocket"
+)
+
+// ---------------------- WebSocket 握手策略 ----------------------
+// Origin 白名单 + 子协议（协议版本）协商，握手被拒时记录原因。
+
+type upgradePolicy struct {
+	allowedOrigins     []string // 为空表示仅允许同源；支持 "*"、"https://*.example.com"
+	allowNoOrigin      bool     // 是否允许无 Origin 头的非浏览器客户端
+	subprotocols       []string // 服务端支持的子协议，按优先级
+	requireSubprotocol bool     // 客户端必须协商出一个受支持的子协议
+}
+
+var wsPolicy = &upgradePolicy{allowNoOrigin: true}
+
+func initUpgrader(ctx context.Context) {
+	wsPolicy = &upgradePolicy{
+		allowedOrigins:     g.Cfg().MustGet(ctx, "websocket.allowedOrigins").Strings(),
+		allowNoOrigin:      g.Cfg().MustGet(ctx, "websocket.allowNoOrigin", true).Bool(),
+		subprotocols:       g.Cfg().MustGet(ctx, "websocket.subprotocols").Strings(),
+		requireSubprotocol: g.Cfg().MustGet(ctx, "websocket.requireSubprotocol").Bool(),
+	}
+	upgrader = websocket.Upgrader{
+		CheckOrigin:  func(r *http.Request) bool { return wsPolicy.checkOrigin(r) == "" },
+		Subprotocols: wsPolicy.subprotocols,
+	}
+}
+
+// check 返回拒绝原因，空字符串表示放行
+func (p *upgradePolicy) check(r *http.Request) string {
+	if reason := p.checkOrigin(r); reason != "" {
+		return reason
+	}
+	if p.requireSubprotocol && p.negotiate(r) == "" {
+		return "unsupported subprotocol: " + strings.Join(websocket.Subprotocols(r), ",")
+	}
+	return ""
+}
+
+func (p *upgradePolicy) checkOrigin(r *http.Request) string {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		if p.allowNoOrigin {
+			return ""
+		}
+		return "missing origin"
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return "malformed origin: " + origin
+	}
+
+	if len(p.allowedOrigins) == 0 {
+		if strings.EqualFold(u.Host, r.Host) {
+			return ""
+		}
+		return "cross-origin not allowed: " + origin
+	}
+	for _, allowed := range p.allowedOrigins {
+		if originMatch(allowed, u) {
+			return ""
+		}
+	}
+	return "origin not in allow-list: " + origin
+}
+
+// originMatch 匹配 "*"、完整 origin，或带子域通配的 "scheme://*.domain"
+func originMatch(pattern string, origin *url.URL) bool {
+	if pattern == "*" {
+		return true
+	}
+	p, err := url.Parse(pattern)
+	if err != nil {
+		return false
+	}
+	if !strings.EqualFold(p.Scheme, origin.Scheme) {
+		return false
+	}
+	if strings.HasPrefix(p.Host, "*.") {
+		suffix := strings.ToLower(p.Host[1:]) // ".example.com"
+		return strings.HasSuffix(strings.ToLower(origin.Host), suffix)
+	}
+	return strings.EqualFold(p.Host, origin.Host)
+}
+
+// negotiate 按服务端优先级选出双方都支持的子协议
+func (p *upgradePolicy) negotiate(r *http.Request) string {
+	offered := websocket.Subprotocols(r)
+	for _, sp := range p.subprotocols {
+		for _, o := range offered {
+			if o == sp {
+				return sp
+			}
+		}
+	}
+	return ""
+}
+
+func logRejectedUpgrade(r *http.Request, reason string) {
+	g.Log().Warningf(r.Context(), "ws upgrade rejected: reason=%q remote=%s origin=%q protocols=%q",
+		reason, r.RemoteAddr, r.Header.Get("Origin"), r.Header.Get("Sec-WebSocket-Protocol"))
+}
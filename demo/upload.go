@@ -0,0 +1,579 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/net/ghttp"
+)
+
+// ---------------------- 分片上传 ----------------------
+// 1. POST /upload/init     登记文件信息，服务端按 upload.chunkSize 切分，返回 upload_id
+// 2. PUT  /upload/chunk    按序号上传分片，请求体为分片原始字节，头 X-Chunk-Sha256 为分片 SHA-256（hex）
+// 3. POST /upload/complete 全部分片到齐后合并，校验整体 SHA-256（init 时提供的话），生成消息
+// 断线后 GET /upload/status 查询已收到的分片，只补传缺失的部分；同一分片可重复上传（覆盖）。
+// 分片以 chunks/<upload_id>/<序号> 写入对象存储（见 storage.go），多节点时任一节点都可接收分片和合并；
+// 合并时在 upload.tmpDir 下生成临时文件，校验后写入最终对象。
+// complete 先以条件更新 uploading -> assembling 抢占合并，并发的 complete 不会重复合并/覆盖对象；合并失败时退回 uploading 可重试。
+// 消息已发出但未能标记完成时留在 assembling，下一次 complete 按 client_msg_id（upload:<upload_id>）找回消息并补记完成。上传会话在 upload.expireHours 后由后台清理。
+// 各消息类型的文件大小上限见 upload.maxSize（按类型名配置），未配置的类型不支持上传。
+
+const (
+	UploadStateUploading  = "uploading"
+	UploadStateAssembling = "assembling" // 某个 complete 请求已抢到合并，其余请求等待
+	UploadStateCompleted  = "completed"
+)
+
+type UploadSession struct {
+	ID         string    `gorm:"primaryKey;column:id;size:32" json:"upload_id"`
+	UserID     int       `gorm:"column:user_id;index" json:"user_id"`
+	SessionID  int       `gorm:"column:session_id" json:"session_id"`
+	ReceiverID int       `gorm:"column:receiver_id" json:"receiver_id"`
+	MsgType    int       `gorm:"column:msg_type" json:"msg_type"`
+	Name       string    `gorm:"column:name;size:255" json:"name"`
+	Mime       string    `gorm:"column:mime;size:128" json:"mime"`
+	Size       int64     `gorm:"column:size" json:"size"`
+	ChunkSize  int64     `gorm:"column:chunk_size" json:"chunk_size"`
+	ChunkCount int       `gorm:"column:chunk_count" json:"chunk_count"`
+	Sha256     string    `gorm:"column:sha256;size:64" json:"sha256"` // 整个文件的校验和，可为空
+	Nickname   string    `gorm:"column:nickname;size:64" json:"nickname"`
+	Avatar     string    `gorm:"column:avatar;size:255" json:"avatar"`
+	State      string    `gorm:"column:state;size:16" json:"state"`
+	MessageID  int       `gorm:"column:message_id" json:"message_id"`
+	ExpiresAt  time.Time `gorm:"column:expires_at;index" json:"expires_at"`
+	CreatedAt  time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+}
+
+func (UploadSession) TableName() string { return "upload_session" }
+
+// UploadChunk 已收到的分片
+type UploadChunk struct {
+	ID         int       `gorm:"primaryKey;column:id" json:"id"`
+	UploadID   string    `gorm:"column:upload_id;size:32;uniqueIndex:uk_upload_chunk,priority:1" json:"upload_id"`
+	ChunkIndex int       `gorm:"column:chunk_index;uniqueIndex:uk_upload_chunk,priority:2" json:"chunk_index"`
+	Size       int64     `gorm:"column:size" json:"size"`
+	Sha256     string    `gorm:"column:sha256;size:64" json:"sha256"`
+	CreatedAt  time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (UploadChunk) TableName() string { return "upload_chunk" }
+
+var uploadCfg = struct {
+	chunkSize int64
+	expire    time.Duration
+	tmpDir    string
+	maxSize   map[int]int64 // msg_type -> 字节上限
+}{
+	chunkSize: 4 << 20,
+	expire:    24 * time.Hour,
+	tmpDir:    "data/upload_tmp",
+	maxSize:   map[int]int64{},
+}
+
+func initUpload(ctx context.Context) {
+	uploadCfg.chunkSize = g.Cfg().MustGet(ctx, "upload.chunkSize", uploadCfg.chunkSize).Int64()
+	if uploadCfg.chunkSize <= 0 {
+		panic("upload.chunkSize must be positive")
+	}
+	uploadCfg.expire = time.Duration(g.Cfg().MustGet(ctx, "upload.expireHours", 24).Int()) * time.Hour
+	uploadCfg.tmpDir = g.Cfg().MustGet(ctx, "upload.tmpDir", uploadCfg.tmpDir).String()
+	for name, v := range g.Cfg().MustGet(ctx, "upload.maxSize").MapStrVar() {
+		t := messageTypeByName(name)
+		if t == nil {
+			g.Log().Warningf(ctx, "upload.maxSize: unknown msg_type %q", name)
+			continue
+		}
+		uploadCfg.maxSize[t.Code] = v.Int64()
+	}
+	go runUploadJanitor(context.Background())
+}
+
+func messageTypeByName(name string) *MessageType {
+	for _, t := range messageTypes {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// checkUploadSize 校验文件大小是否在该类型的上限内
+func checkUploadSize(msgType int, size int64) *bizError {
+	limit := uploadCfg.maxSize[msgType]
+	if limit <= 0 {
+		return newBizError(400, "该类型消息不支持上传文件")
+	}
+	if size > limit {
+		return newBizError(400, fmt.Sprintf("文件大小不能超过 %d 字节", limit))
+	}
+	return nil
+}
+
+func newUploadID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func chunkKey(uploadID string, index int) string {
+	return fmt.Sprintf("chunks/%s/%d", uploadID, index)
+}
+
+// deleteChunks 删除已上传的分片对象
+func deleteChunks(ctx context.Context, uploadID string) {
+	chunks, err := store.Uploads.Chunks(ctx, uploadID)
+	if err != nil {
+		return
+	}
+	for _, c := range chunks {
+		if err := objectStorage.Delete(ctx, chunkKey(uploadID, c.ChunkIndex)); err != nil {
+			g.Log().Warningf(ctx, "delete chunk failed, upload=%s index=%d: %v", uploadID, c.ChunkIndex, err)
+		}
+	}
+}
+
+// expectedChunkSize 最后一片为余下的部分
+func (u *UploadSession) expectedChunkSize(index int) int64 {
+	if index == u.ChunkCount-1 {
+		return u.Size - int64(index)*u.ChunkSize
+	}
+	return u.ChunkSize
+}
+
+type UploadInitReq struct {
+	SessionID  int    `json:"session_id"`
+	SendID     int    `json:"send_id"`
+	ReceiverID int    `json:"receiver_id"`
+	MsgType    int    `json:"msg_type"`
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	Mime       string `json:"mime"`
+	Sha256     string `json:"sha256"` // 可选，整个文件的 SHA-256（hex）
+	Nickname   string `json:"nickname"`
+	Avatar     string `json:"avatar"`
+}
+
+func createUpload(ctx context.Context, req *UploadInitReq) (*UploadSession, *bizError) {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Sha256 = strings.ToLower(req.Sha256)
+	if req.SessionID == 0 || req.SendID == 0 || req.MsgType == 0 || req.Size <= 0 ||
+		req.Name == "" || utf8.RuneCountInString(req.Name) > 255 || len(req.Mime) > 128 {
+		return nil, newBizError(400, "参数不完整")
+	}
+	if req.Sha256 != "" && !isSha256Hex(req.Sha256) {
+		return nil, newBizError(400, "sha256 格式错误")
+	}
+	// 扩展名会拼进对象 key，提前校验，避免全部分片上传后才在合并时失败
+	if _, ok := objectExt(req.Name); !ok {
+		return nil, newBizError(400, "文件扩展名只能是 1~16 位字母或数字")
+	}
+	if bizErr := checkUploadSize(req.MsgType, req.Size); bizErr != nil {
+		return nil, bizErr
+	}
+	// 上传前预先校验发送者是否在会话中（合并后发送时会再校验一次）
+	if bizErr := checkParticipant(ctx, req.SessionID, req.SendID); bizErr != nil {
+		return nil, bizErr
+	}
+	u := &UploadSession{
+		ID:         newUploadID(),
+		UserID:     req.SendID,
+		SessionID:  req.SessionID,
+		ReceiverID: req.ReceiverID,
+		MsgType:    req.MsgType,
+		Name:       req.Name,
+		Mime:       req.Mime,
+		Size:       req.Size,
+		ChunkSize:  uploadCfg.chunkSize,
+		ChunkCount: int((req.Size + uploadCfg.chunkSize - 1) / uploadCfg.chunkSize),
+		Sha256:     req.Sha256,
+		Nickname:   req.Nickname,
+		Avatar:     req.Avatar,
+		State:      UploadStateUploading,
+		ExpiresAt:  time.Now().Add(uploadCfg.expire),
+	}
+	if err := store.Uploads.Create(ctx, u); err != nil {
+		g.Log().Warningf(ctx, "create upload failed: %v", err)
+		return nil, newBizError(500, "创建上传失败")
+	}
+	return u, nil
+}
+
+func isSha256Hex(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == sha256.Size
+}
+
+// loadUpload 只有发起者可以访问自己的上传会话
+func loadUpload(ctx context.Context, uid int, uploadID string) (*UploadSession, *bizError) {
+	u, err := store.Uploads.Get(ctx, uploadID)
+	if err != nil || u.UserID != uid {
+		return nil, newBizError(404, "上传不存在")
+	}
+	return u, nil
+}
+
+// receivedChunks 已收到的分片序号（升序）
+func receivedChunks(ctx context.Context, uploadID string) ([]int, error) {
+	chunks, err := store.Uploads.Chunks(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]int, 0, len(chunks))
+	for _, c := range chunks {
+		list = append(list, c.ChunkIndex)
+	}
+	return list, nil
+}
+
+// putChunk 校验并保存一个分片；重复上传同一分片时覆盖
+func putChunk(ctx context.Context, uid int, uploadID string, index int, sum string, data []byte) *bizError {
+	u, bizErr := loadUpload(ctx, uid, uploadID)
+	if bizErr != nil {
+		return bizErr
+	}
+	switch u.State {
+	case UploadStateUploading:
+	case UploadStateAssembling:
+		return newBizError(400, "文件正在合并")
+	default:
+		return newBizError(400, "上传已完成")
+	}
+	if time.Now().After(u.ExpiresAt) {
+		return newBizError(400, "上传已过期")
+	}
+	if index < 0 || index >= u.ChunkCount {
+		return newBizError(400, "分片序号错误")
+	}
+	if int64(len(data)) != u.expectedChunkSize(index) {
+		return newBizError(400, "分片大小不符")
+	}
+	digest := sha256.Sum256(data)
+	actual := hex.EncodeToString(digest[:])
+	if !strings.EqualFold(sum, actual) {
+		return newBizError(400, "分片校验失败")
+	}
+
+	if err := objectStorage.Put(ctx, chunkKey(uploadID, index), bytes.NewReader(data), int64(len(data)), "application/octet-stream"); err != nil {
+		g.Log().Warningf(ctx, "put chunk failed, upload=%s index=%d: %v", uploadID, index, err)
+		return newBizError(500, "保存分片失败")
+	}
+	if err := store.Uploads.PutChunk(ctx, &UploadChunk{
+		UploadID: uploadID, ChunkIndex: index, Size: int64(len(data)), Sha256: actual,
+	}); err != nil {
+		g.Log().Warningf(ctx, "save chunk failed, upload=%s index=%d: %v", uploadID, index, err)
+		return newBizError(500, "保存分片失败")
+	}
+	return nil
+}
+
+// completeUpload 合并分片并发送消息；已完成的上传直接返回之前生成的消息
+func completeUpload(ctx context.Context, uid int, uploadID string) (*TalkMessage, *bizError) {
+	u, bizErr := loadUpload(ctx, uid, uploadID)
+	if bizErr != nil {
+		return nil, bizErr
+	}
+	if u.State != UploadStateUploading {
+		return uploadedMessage(ctx, u)
+	}
+	if time.Now().After(u.ExpiresAt) {
+		return nil, newBizError(400, "上传已过期")
+	}
+	received, err := receivedChunks(ctx, uploadID)
+	if err != nil {
+		return nil, newBizError(500, "查询失败")
+	}
+	if len(received) != u.ChunkCount {
+		return nil, newBizError(400, fmt.Sprintf("分片不完整：已收到 %d/%d", len(received), u.ChunkCount))
+	}
+
+	// 抢占合并：并发的 complete 只有一个能继续，其余按当前状态返回
+	claimed, err := store.Uploads.Transition(ctx, u.ID, UploadStateUploading, UploadStateAssembling)
+	if err != nil {
+		return nil, newBizError(500, "合并失败")
+	}
+	if !claimed {
+		if u, err = store.Uploads.Get(ctx, u.ID); err != nil {
+			return nil, newBizError(404, "上传不存在")
+		}
+		return uploadedMessage(ctx, u)
+	}
+	msg, bizErr := assembleUpload(ctx, u)
+	if bizErr != nil {
+		// 退回 uploading，客户端可补传分片后重试
+		if _, err := store.Uploads.Transition(ctx, u.ID, UploadStateAssembling, UploadStateUploading); err != nil {
+			g.Log().Warningf(ctx, "reset upload state failed, upload=%s: %v", u.ID, err)
+		}
+		return nil, bizErr
+	}
+	return msg, nil
+}
+
+// uploadedMessage 已完成的上传返回之前生成的消息，合并中的提示稍后再试
+func uploadedMessage(ctx context.Context, u *UploadSession) (*TalkMessage, *bizError) {
+	if u.State == UploadStateAssembling {
+		// 消息已发出但没能标记完成（Complete 失败或节点中途退出）时，按 client_msg_id 找回消息并补记完成
+		msg, err := store.Messages.FindByClientMsgID(ctx, u.UserID, uploadClientMsgID(u.ID))
+		if err != nil {
+			return nil, newBizError(409, "文件正在合并，请稍后重试")
+		}
+		markUploadCompleted(ctx, u.ID, msg.ID)
+		return msg, nil
+	}
+	if u.State != UploadStateCompleted {
+		return nil, newBizError(409, "文件正在合并，请稍后重试")
+	}
+	msg, err := store.Messages.Get(ctx, u.MessageID)
+	if err != nil {
+		return nil, newBizError(404, "消息不存在")
+	}
+	return msg, nil
+}
+
+// assembleUpload 合并分片、生成消息并标记完成，调用方已抢到 assembling
+func assembleUpload(ctx context.Context, u *UploadSession) (*TalkMessage, *bizError) {
+	key := objectKey(u.MsgType, u.ID, u.Name)
+	if bizErr := assembleChunks(ctx, u, key); bizErr != nil {
+		return nil, bizErr
+	}
+
+	payload, _ := json.Marshal(g.Map{
+		"url":  objectPath(key),
+		"name": u.Name,
+		"size": u.Size,
+		"mime": u.Mime,
+	})
+	// client_msg_id 取 upload_id：并发或重复 complete 只生成一条消息
+	msg, bizErr := sendMessage(ctx, &SendMessageReq{
+		SessionID:   u.SessionID,
+		SendID:      u.UserID,
+		ReceiverID:  u.ReceiverID,
+		MsgType:     u.MsgType,
+		Payload:     payload,
+		Nickname:    u.Nickname,
+		Avatar:      u.Avatar,
+		ClientMsgID: uploadClientMsgID(u.ID),
+		ObjectKey:   key,
+	})
+	if bizErr != nil {
+		_ = objectStorage.Delete(ctx, key)
+		return nil, bizErr
+	}
+	deleteChunks(ctx, u.ID)
+	markUploadCompleted(ctx, u.ID, msg.ID)
+	return msg, nil
+}
+
+func uploadClientMsgID(uploadID string) string {
+	return "upload:" + uploadID
+}
+
+// markUploadCompleted 消息已发出后标记完成，短暂重试；仍失败时留在 assembling，下次 complete 按 client_msg_id 补记
+func markUploadCompleted(ctx context.Context, uploadID string, messageID int) {
+	var err error
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * 100 * time.Millisecond)
+		}
+		if err = store.Uploads.Complete(ctx, uploadID, messageID); err == nil {
+			return
+		}
+	}
+	g.Log().Warningf(ctx, "mark upload completed failed, upload=%s: %v", uploadID, err)
+}
+
+// assembleChunks 按序把分片合并到本地临时文件，校验总大小与整体 SHA-256 后写入对象 key
+func assembleChunks(ctx context.Context, u *UploadSession, key string) *bizError {
+	if err := os.MkdirAll(uploadCfg.tmpDir, 0755); err != nil {
+		return newBizError(500, "创建目录失败")
+	}
+	f, err := os.CreateTemp(uploadCfg.tmpDir, u.ID+"-*")
+	if err != nil {
+		return newBizError(500, "保存失败")
+	}
+	defer func() {
+		f.Close()
+		_ = os.Remove(f.Name())
+	}()
+	hash := sha256.New()
+	w := io.MultiWriter(f, hash)
+	var total int64
+	for i := 0; i < u.ChunkCount; i++ {
+		n, err := copyChunk(ctx, w, chunkKey(u.ID, i))
+		if err != nil {
+			g.Log().Warningf(ctx, "read chunk failed, upload=%s index=%d: %v", u.ID, i, err)
+			return newBizError(400, fmt.Sprintf("分片 %d 缺失，请重新上传", i))
+		}
+		total += n
+	}
+	if total != u.Size || (u.Sha256 != "" && hex.EncodeToString(hash.Sum(nil)) != u.Sha256) {
+		return newBizError(400, "文件校验失败")
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return newBizError(500, "保存失败")
+	}
+	if err := objectStorage.Put(ctx, key, f, total, u.Mime); err != nil {
+		g.Log().Warningf(ctx, "put object failed, key=%s: %v", key, err)
+		return newBizError(500, "保存失败")
+	}
+	return nil
+}
+
+func copyChunk(ctx context.Context, w io.Writer, key string) (int64, error) {
+	rc, err := objectStorage.Open(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(w, rc)
+}
+
+const uploadJanitorBatch = 100
+
+// runUploadJanitor 定期清理过期的上传会话及其暂存分片（已生成的文件不受影响）
+func runUploadJanitor(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cleanExpiredUploads(ctx)
+	}
+}
+
+func cleanExpiredUploads(ctx context.Context) {
+	for {
+		list, err := store.Uploads.Expired(ctx, time.Now(), uploadJanitorBatch)
+		if err != nil {
+			g.Log().Warningf(ctx, "list expired uploads failed: %v", err)
+			return
+		}
+		for _, u := range list {
+			deleteChunks(ctx, u.ID)
+			if err := store.Uploads.Delete(ctx, u.ID); err != nil {
+				g.Log().Warningf(ctx, "delete upload failed, upload=%s: %v", u.ID, err)
+				return
+			}
+		}
+		if len(list) < uploadJanitorBatch {
+			return
+		}
+	}
+}
+
+// ---------------------- HTTP Handlers ----------------------
+
+// 创建分片上传
+// POST /upload/init
+// body: { "session_id":1, "send_id":1, "receiver_id":2, "msg_type":3, "name":"报价单.pdf", "size":10485760, "mime":"application/pdf", "sha256":"..." }
+func uploadInitHandler(r *ghttp.Request) {
+	var req UploadInitReq
+	err := r.Parse(&req)
+	req.SendID = authUID(r, req.SendID) // 以令牌身份为准
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "message": "参数错误"})
+		return
+	}
+	u, bizErr := createUpload(r.Context(), &req)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "message": bizErr.Msg})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "message": "success", "data": g.Map{
+		"upload_id":   u.ID,
+		"chunk_size":  u.ChunkSize,
+		"chunk_count": u.ChunkCount,
+		"expires_at":  u.ExpiresAt.Format("2006-01-02 15:04:05"),
+		"received":    []int{},
+	}})
+}
+
+// 上传分片（请求体为分片原始字节）
+// PUT /upload/chunk?upload_id=xxx&index=0
+// header: X-Chunk-Sha256: <分片 SHA-256 hex>，令牌请放在 Authorization 头
+func uploadChunkHandler(r *ghttp.Request) {
+	// 参数只从 query 取，避免把二进制请求体当表单解析
+	uid := authUID(r, r.GetQuery("user_id").Int()) // 以令牌身份为准
+	uploadID := r.GetQuery("upload_id").String()
+	index := r.GetQuery("index", -1).Int()
+	sum := r.Header.Get("X-Chunk-Sha256")
+	if uid == 0 || uploadID == "" || sum == "" {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "message": "参数不完整"})
+		return
+	}
+	if bizErr := putChunk(r.Context(), uid, uploadID, index, sum, r.GetBody()); bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "message": bizErr.Msg})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "message": "success", "data": g.Map{"index": index}})
+}
+
+// 查询上传进度（断点续传）
+// GET /upload/status?upload_id=xxx
+func uploadStatusHandler(r *ghttp.Request) {
+	uid := authUID(r, r.Get("user_id").Int()) // 以令牌身份为准
+	ctx := r.Context()
+	u, bizErr := loadUpload(ctx, uid, r.Get("upload_id").String())
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "message": bizErr.Msg})
+		return
+	}
+	received, err := receivedChunks(ctx, u.ID)
+	if err != nil {
+		r.Response.WriteJsonExit(g.Map{"code": 500, "message": "查询失败"})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{"code": 0, "message": "success", "data": g.Map{
+		"upload_id":   u.ID,
+		"state":       u.State,
+		"chunk_size":  u.ChunkSize,
+		"chunk_count": u.ChunkCount,
+		"expires_at":  u.ExpiresAt.Format("2006-01-02 15:04:05"),
+		"received":    received,
+		"msg_id":      u.MessageID,
+	}})
+}
+
+// 完成上传：合并分片并发送消息
+// POST /upload/complete
+// body: { "upload_id":"xxx", "user_id":1 }
+func uploadCompleteHandler(r *ghttp.Request) {
+	var req struct {
+		UploadID string `json:"upload_id"`
+		UserID   int    `json:"user_id"`
+	}
+	err := r.Parse(&req)
+	req.UserID = authUID(r, req.UserID) // 以令牌身份为准
+	if err != nil || req.UploadID == "" || req.UserID == 0 {
+		r.Response.WriteJsonExit(g.Map{"code": 400, "message": "参数不完整"})
+		return
+	}
+	msg, bizErr := completeUpload(r.Context(), req.UserID, req.UploadID)
+	if bizErr != nil {
+		r.Response.WriteJsonExit(g.Map{"code": bizErr.Code, "message": bizErr.Msg})
+		return
+	}
+	r.Response.WriteJsonExit(g.Map{
+		"code":    0,
+		"message": "上传成功",
+		"data": g.Map{
+			"file_url":   fileURLOf(msg),
+			"msg_id":     msg.ID,
+			"created_at": msg.CreatedAt.Format("2006-01-02 15:04:05"),
+		},
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentCompleteAssemblesOnce(t *testing.T) {
+	ctx := context.Background()
+	store = newMemoryStores()
+	searchIndex = newMemorySearchIndex()
+	objectStorage = &localStorage{root: t.TempDir(), secret: "test-secret"}
+	t.Cleanup(func() { objectStorage = &localStorage{root: "data/objects", secret: randomSecret()} })
+	saved := uploadCfg
+	t.Cleanup(func() { uploadCfg = saved })
+	uploadCfg.chunkSize = 4
+	uploadCfg.maxSize = map[int]int64{}
+	uploadCfg.tmpDir = t.TempDir()
+	uploadCfg.maxSize[MsgTypeFile] = 1 << 20
+
+	sess := &TalkSession{SendID: 1, ReceiverID: 2, Status: 1, Type: 1, UpdatedAt: time.Now()}
+	if err := store.Sessions.Create(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("hello, chunks")
+	u, bizErr := createUpload(ctx, &UploadInitReq{SessionID: sess.ID, SendID: 1, ReceiverID: 2, MsgType: MsgTypeFile, Name: "a.txt", Size: int64(len(data))})
+	if bizErr != nil {
+		t.Fatalf("init: %+v", bizErr)
+	}
+	for i := 0; i < u.ChunkCount; i++ {
+		end := min((i+1)*4, len(data))
+		sum := sha256.Sum256(data[i*4 : end])
+		if bizErr := putChunk(ctx, 1, u.ID, i, hex.EncodeToString(sum[:]), data[i*4:end]); bizErr != nil {
+			t.Fatalf("chunk %d: %+v", i, bizErr)
+		}
+	}
+
+	// 并发 complete：只有一个请求合并，其余返回同一条消息或 409
+	const n = 8
+	var wg sync.WaitGroup
+	ids := make([]int, n)
+	codes := make([]int, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg, bizErr := completeUpload(ctx, 1, u.ID)
+			if bizErr != nil {
+				codes[i] = bizErr.Code
+				return
+			}
+			ids[i] = msg.ID
+		}(i)
+	}
+	wg.Wait()
+	msgID := 0
+	for i := 0; i < n; i++ {
+		switch {
+		case codes[i] == 409:
+		case codes[i] != 0:
+			t.Fatalf("complete %d: code %d", i, codes[i])
+		case msgID == 0:
+			msgID = ids[i]
+		case ids[i] != msgID:
+			t.Fatalf("complete produced messages %d and %d", msgID, ids[i])
+		}
+	}
+	if msgID == 0 {
+		t.Fatal("no complete succeeded")
+	}
+	if list, _ := store.Messages.List(ctx, MessageQuery{Sid: sess.ID, Limit: 10}); len(list) != 1 {
+		t.Fatalf("messages = %d, want 1", len(list))
+	}
+	if got, _ := store.Uploads.Get(ctx, u.ID); got.State != UploadStateCompleted || got.MessageID != msgID {
+		t.Fatalf("upload = %+v", got)
+	}
+	// 完成后重复 complete 返回同一条消息
+	if msg, bizErr := completeUpload(ctx, 1, u.ID); bizErr != nil || msg.ID != msgID {
+		t.Fatalf("repeat complete: %+v %+v", msg, bizErr)
+	}
+
+	// 消息已发出但没能标记完成：下一次 complete 找回消息并补记完成，而不是一直 409
+	if ok, _ := store.Uploads.Transition(ctx, u.ID, UploadStateCompleted, UploadStateAssembling); !ok {
+		t.Fatal("reset to assembling failed")
+	}
+	if msg, bizErr := completeUpload(ctx, 1, u.ID); bizErr != nil || msg.ID != msgID {
+		t.Fatalf("complete while stuck assembling: %+v %+v", msg, bizErr)
+	}
+	if got, _ := store.Uploads.Get(ctx, u.ID); got.State != UploadStateCompleted {
+		t.Fatalf("state after recovery = %s", got.State)
+	}
+}
+
+func TestUploadRejectsBadExtension(t *testing.T) {
+	ctx := context.Background()
+	store = newMemoryStores()
+	saved := uploadCfg
+	t.Cleanup(func() { uploadCfg = saved })
+	uploadCfg.maxSize = map[int]int64{MsgTypeFile: 1 << 20}
+	sess := &TalkSession{SendID: 1, ReceiverID: 2, Status: 1, Type: 1, UpdatedAt: time.Now()}
+	if err := store.Sessions.Create(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+	create := func(name string) *bizError {
+		_, bizErr := createUpload(ctx, &UploadInitReq{SessionID: sess.ID, SendID: 1, ReceiverID: 2, MsgType: MsgTypeFile, Name: name, Size: 10})
+		return bizErr
+	}
+	for _, name := range []string{"a.t\\xt", "a." + strings.Repeat("x", 17), "a.", "a.tx t"} {
+		if bizErr := create(name); bizErr == nil || bizErr.Code != 400 {
+			t.Fatalf("%q: %+v, want 400", name, bizErr)
+		}
+	}
+	for _, name := range []string{"a.PDF", "README", "a.tar.gz", "a." + strings.Repeat("x", 16)} {
+		if bizErr := create(name); bizErr != nil {
+			t.Fatalf("%q: %+v", name, bizErr)
+		}
+		if key := objectKey(MsgTypeFile, newUploadID(), name); !validObjectKey(key) {
+			t.Fatalf("%q: invalid key %q", name, key)
+		}
+	}
+}
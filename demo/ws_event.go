@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// ---------------------- WS 客户端事件 ----------------------
+// 客户端上行帧：{"event":"im.send","req_id":"c-1","data":{...}}
+// 业务事件处理后回 ack 帧，req_id 原样带回用于客户端关联请求：
+// {"event":"ack","req_id":"c-1","code":0,"msg":"success","data":{...}}
+type wsFrame struct {
+	Event string          `json:"event"`
+	ReqID string          `json:"req_id"`
+	Data  json.RawMessage `json:"data"`
+}
+
+func sendAck(c *Client, in *wsFrame, code int, msg string, data any) {
+	sendWS(c, map[string]any{
+		"event":  "ack",
+		"req_id": in.ReqID,
+		"ack":    in.Event,
+		"code":   code,
+		"msg":    msg,
+		"data":   data,
+	})
+}
+
+func sendAckError(c *Client, in *wsFrame, bizErr *bizError) {
+	sendAck(c, in, bizErr.Code, bizErr.Msg, nil)
+}
+
+// im.send 发送消息，与 POST /talk/message/send 相同，发送者取连接身份
+// data: { "session_id":1001, "receiver_id":2, "msg_type":1, "content":"你好", "nickname":"张三", "avatar":"https://...", "client_msg_id":"c-uuid"(可选), "reply_to_id":5000(可选), "payload":{...}(可选) }
+func wsSendMessage(ctx context.Context, c *Client, in *wsFrame) {
+	var req SendMessageReq
+	if err := json.Unmarshal(in.Data, &req); err != nil {
+		sendAckError(c, in, newBizError(400, "参数错误"))
+		return
+	}
+	req.SendID = c.UserID
+
+	msg, bizErr := sendMessage(ctx, &req)
+	if bizErr != nil {
+		sendAckError(c, in, bizErr)
+		return
+	}
+	sendAck(c, in, 0, "success", map[string]any{"id": msg.ID, "message": msg})
+}
+
+// im.read 会话已读，与 POST /talk/message/read 相同
+// data: { "session_id":1001 }
+func wsMarkRead(ctx context.Context, c *Client, in *wsFrame) {
+	var req struct {
+		SessionID int `json:"session_id"`
+	}
+	if err := json.Unmarshal(in.Data, &req); err != nil {
+		sendAckError(c, in, newBizError(400, "参数错误"))
+		return
+	}
+	if bizErr := markSessionRead(ctx, req.SessionID, c.UserID); bizErr != nil {
+		sendAckError(c, in, bizErr)
+		return
+	}
+	sendAck(c, in, 0, "ok", map[string]any{"session_id": req.SessionID})
+}
+
+// im.ack 确认送达
+// data: { "ids":[101,102] }
+func wsAckDelivered(ctx context.Context, c *Client, in *wsFrame) {
+	var req struct {
+		IDs []int `json:"ids"`
+	}
+	if err := json.Unmarshal(in.Data, &req); err != nil {
+		sendAckError(c, in, newBizError(400, "参数错误"))
+		return
+	}
+	ids, bizErr := markDelivered(ctx, c.UserID, req.IDs)
+	if bizErr != nil {
+		sendAckError(c, in, bizErr)
+		return
+	}
+	sendAck(c, in, 0, "ok", map[string]any{"ids": ids})
+}
+
+// im.sync 增量同步，与 POST /talk/message/sync 相同；重连后用最后收到的 seq 拉取错过的消息
+// data: { "after_seq":120, "limit":100 }
+func wsSyncMessages(ctx context.Context, c *Client, in *wsFrame) {
+	var req struct {
+		AfterSeq int64 `json:"after_seq"`
+		Limit    int   `json:"limit"`
+	}
+	if len(in.Data) > 0 {
+		if err := json.Unmarshal(in.Data, &req); err != nil {
+			sendAckError(c, in, newBizError(400, "参数错误"))
+			return
+		}
+	}
+	list, hasMore, bizErr := syncMessages(ctx, c.UserID, req.AfterSeq, req.Limit)
+	if bizErr != nil {
+		sendAckError(c, in, bizErr)
+		return
+	}
+	sendAck(c, in, 0, "success", map[string]any{"list": list, "has_more": hasMore})
+}